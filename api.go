@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// apiState is the JSON served at /api/state
+type apiState struct {
+	Station     string     `json:"station"`
+	Name        string     `json:"name"`
+	GridState   string     `json:"grid_state"` // The announced state: "up", "down" or "unknown" before the first reading
+	SOC         *int       `json:"soc"`
+	Ppv         *int       `json:"ppv"`
+	Pload       *int       `json:"pload"`
+	LastPoll    *time.Time `json:"last_poll"`    // Last successful poll of any station
+	OutageSince *time.Time `json:"outage_since"` // Start of the announced outage, null while the grid is up or if unknown
+}
+
+// apiPoll is the JSON served at /api/poll
+type apiPoll struct {
+	Station   string    `json:"station"`
+	Name      string    `json:"name"`
+	GridState string    `json:"grid_state"` // Of this reading, "up" or "down", whatever has been announced
+	Grid      int       `json:"grid"`       // GridToLoad in W
+	SOC       *int      `json:"soc"`
+	Ppv       *int      `json:"ppv"`
+	Pload     *int      `json:"pload"`
+	PolledAt  time.Time `json:"polled_at"`
+}
+
+// serveAPI exposes the current state at /api/state on addr, for dashboards and home automation, and
+// a fresh poll at /api/poll. /api/state serves the readings of the last poll and never polls LuxPower
+// itself. It only returns if the server fails.
+func (b *Bot) serveAPI(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/state", b.handleAPIState)
+	mux.HandleFunc("/api/poll", b.handleAPIPoll)
+
+	slog.Info("Serving API", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("API server failed", "err", err)
+	}
+}
+
+// authorizeAPI checks the API_TOKEN bearer token and the method of r, answering it if either is wrong
+func authorizeAPI(w http.ResponseWriter, r *http.Request, method string) bool {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+apiToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if r.Method != method {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// apiStation returns the station given by ?station=ID, the first configured one by default,
+// answering r with 404 if there is none. The stations never change, so b.mu isn't needed.
+func (b *Bot) apiStation(w http.ResponseWriter, r *http.Request) *station {
+	i := 0
+	if id := r.URL.Query().Get("station"); id != "" {
+		i = slices.IndexFunc(b.stations, func(st *station) bool { return st.id == id })
+	}
+	if i < 0 {
+		http.Error(w, "unknown station", http.StatusNotFound)
+		return nil
+	}
+	return b.stations[i]
+}
+
+// handleAPIState serves the state of the station given by ?station=ID, the first configured one by default
+func (b *Bot) handleAPIState(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAPI(w, r, http.MethodGet) {
+		return
+	}
+	st := b.apiStation(w, r)
+	if st == nil {
+		return
+	}
+
+	b.mu.Lock()
+	state := apiState{Station: st.id, Name: st.name, GridState: "unknown", SOC: st.soc, Ppv: st.ppv, Pload: st.pload}
+	if isGridDown(st.previousGridState) {
+		state.GridState = "down"
+	} else if st.previousGridState >= 0 {
+		state.GridState = "up"
+	}
+	if !b.lastPollTime.IsZero() {
+		state.LastPoll = &b.lastPollTime
+	}
+	if isGridDown(st.previousGridState) && !st.outageStart.IsZero() {
+		state.OutageSince = &st.outageStart
+	}
+	data, err := json.Marshal(state) // Marshalled under the lock, the pointers point into the bot
+	b.mu.Unlock()
+
+	if err != nil {
+		slog.Error("Error encoding API state", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleAPIPoll polls the station given by ?station=ID right away, outside the CHECK_INTERVAL schedule,
+// and serves the reading, e.g. for an automation that wants to know now. Requests arriving while a poll
+// of the station is running share its result, so they can't flood the LuxPower cloud. The reading isn't
+// fed into the state machine: announcements keep their confirmation pace however often this is called.
+func (b *Bot) handleAPIPoll(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAPI(w, r, http.MethodPost) {
+		return
+	}
+	st := b.apiStation(w, r)
+	if st == nil {
+		return
+	}
+
+	// Not bound to the request, the poll may be serving other requests too. After /setstation a poll
+	// still running against the previous station isn't shared, hence the generation in the key.
+	generation := b.stationGeneration(st)
+	key := st.id + "/" + strconv.Itoa(generation)
+	result, err, shared := b.manualPolls.Do(key, func() (any, error) {
+		response, err := b.getLiveData(context.Background(), st)
+		if err != nil {
+			return nil, err
+		}
+		if b.stationGeneration(st) != generation {
+			return nil, errors.New("the station was switched with /setstation while polling, try again")
+		}
+		poll := apiPoll{Station: st.id, Name: st.name, GridState: "up", Grid: response.GridToLoad, SOC: response.SOC, Ppv: response.Ppv, Pload: response.Pload, PolledAt: clock.Now().In(location)}
+		if isGridDown(response.GridToLoad) {
+			poll.GridState = "down"
+		}
+		return json.Marshal(poll)
+	})
+	if err != nil {
+		slog.Error("Error polling for the API", "station", st.id, "err", err)
+		http.Error(w, "polling failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	slog.Debug("Polled for the API", "station", st.id, "shared", shared)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result.([]byte))
+}
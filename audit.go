@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditLog appends a JSON line for every notification to AUDIT_LOG, to show later whether an alert
+// went out. A nil auditLog, without AUDIT_LOG, records nothing.
+type auditLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// auditEntry is one line of the audit log
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Chat    string    `json:"chat"`            // Chat ID or @channel
+	Message string    `json:"message"`         // The text without markup
+	Result  string    `json:"result"`          // sent, failed or duplicate
+	Error   string    `json:"error,omitempty"` // Why it failed
+}
+
+// openAuditLog opens or creates the log at path for appending
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{f: f}, nil
+}
+
+// record appends a notification to chat. err is the send error, nil if it was sent.
+func (a *auditLog) record(chat, text, result string, err error) {
+	if a == nil {
+		return
+	}
+
+	entry := auditEntry{Time: clock.Now(), Chat: chat, Message: formatText("", text), Result: result}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	line, _ := json.Marshal(entry) // Can't fail for these fields
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		slog.Error("Error writing audit log", "err", err)
+	}
+}
+
+// recordSend records a send attempt, sent or failed depending on err
+func (a *auditLog) recordSend(chat, text string, err error) {
+	result := "sent"
+	if err != nil {
+		result = "failed"
+	}
+	a.record(chat, text, result, err)
+}
+
+// close closes the log
+func (a *auditLog) close() {
+	if a == nil {
+		return
+	}
+	if err := a.f.Close(); err != nil {
+		slog.Error("Error closing audit log", "err", err)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import "time"
+
+// batteryRuntime estimates how long the battery of st lasts at the current net discharge:
+// SOC * BATTERY_CAPACITY_WH / (load - PV). charging is true when PV covers the load.
+// ok is false if the capacity isn't configured or a reading is missing. Must be called with b.mu held.
+func batteryRuntime(st *station) (runtime time.Duration, charging, ok bool) {
+	if batteryCapacityWh <= 0 || st.soc == nil || st.ppv == nil || st.pload == nil {
+		return 0, false, false
+	}
+
+	discharge := *st.pload - *st.ppv // W
+	if discharge <= 0 {
+		return 0, true, true
+	}
+	remaining := float64(*st.soc) / 100 * float64(batteryCapacityWh) // Wh
+	return time.Duration(remaining / float64(discharge) * float64(time.Hour)), false, true
+}
+
+// batteryRuntimeText describes batteryRuntime for a notification, empty if there is no estimate.
+// Must be called with b.mu held.
+func batteryRuntimeText(lang string, st *station) string {
+	runtime, charging, ok := batteryRuntime(st)
+	switch {
+	case !ok:
+		return ""
+	case charging:
+		return tr(lang, "battery_charging")
+	default:
+		return tr(lang, "battery_runtime", formatDuration(lang, runtime))
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// category is a kind of notification a chat can turn off with /notify
+type category string
+
+const (
+	categoryGrid    category = "grid"    // Outages, restores and restart announcements
+	categoryBattery category = "battery" // BATTERY_LOW_THRESHOLD alerts
+	categorySummary category = "summary" // DAILY_SUMMARY_AT recaps
+	categoryImport  category = "import"  // GRID_IMPORT_ALERT alerts
+)
+
+// categories are listed by /notify in this order
+var categories = []category{categoryGrid, categoryBattery, categorySummary, categoryImport}
+
+// wantsCategory reports whether a chat gets notifications of category c. Every category is on
+// until the chat turns it off. Must be called with b.mu held.
+func (b *Bot) wantsCategory(chatID int64, c category) bool {
+	return !slices.Contains(b.chats[chatID].Disabled, c)
+}
+
+// handleNotifyCommand turns a notification category on or off for the chat, "/notify battery off",
+// and lists the categories with their state
+func (b *Bot) handleNotifyCommand(chatID int64, args string) {
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	b.mu.Unlock()
+
+	if args != "" {
+		fields := strings.Fields(args)
+		if len(fields) != 2 || !slices.Contains(categories, category(fields[0])) || (fields[1] != "on" && fields[1] != "off") {
+			b.sendMessageToGroup(chatID, tr(lang, "notify_usage"))
+			return
+		}
+
+		c, on := category(fields[0]), fields[1] == "on"
+		b.mu.Lock()
+		b.updateChat(chatID, func(chat *ChatInfo) {
+			chat.Disabled = slices.DeleteFunc(chat.Disabled, func(d category) bool { return d == c })
+			if !on {
+				chat.Disabled = append(chat.Disabled, c)
+			}
+		})
+		b.saveState()
+		b.mu.Unlock()
+		slog.Info("Notification category changed", "chat_id", chatID, "category", c, "on", on)
+	}
+
+	b.mu.Lock()
+	lines := []string{tr(lang, "notify_list")}
+	for _, c := range categories {
+		state := tr(lang, "notify_on")
+		if !b.wantsCategory(chatID, c) {
+			state = tr(lang, "notify_off")
+		}
+		lines = append(lines, tr(lang, "notify_line", c, tr(lang, "notify_category_"+string(c)), state))
+	}
+	b.mu.Unlock()
+
+	b.sendMessageToGroup(chatID, strings.Join(lines, "\n")+"\n\n"+tr(lang, "notify_usage"))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// broadcastChannel is a channel from BROADCAST_CHANNELS. Channels never message the bot,
+// so they are configured by numeric ID or by @username instead of being discovered.
+type broadcastChannel struct {
+	id       int64  // Zero when given by username
+	username string // With the leading @
+}
+
+// parseChannels parses a comma-separated list of channel IDs and @usernames
+func parseChannels(value string) []broadcastChannel {
+	var channels []broadcastChannel
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		switch {
+		case field == "":
+		case strings.HasPrefix(field, "@"):
+			channels = append(channels, broadcastChannel{username: field})
+		default:
+			id, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				slog.Warn("Invalid channel in BROADCAST_CHANNELS, use a numeric ID or @username", "value", field)
+				continue
+			}
+			channels = append(channels, broadcastChannel{id: id})
+		}
+	}
+	return channels
+}
+
+func (c broadcastChannel) String() string {
+	if c.username != "" {
+		return c.username
+	}
+	return strconv.FormatInt(c.id, 10)
+}
+
+// isBroadcastChannel reports whether chatID is one of BROADCAST_CHANNELS, which are notified separately
+func isBroadcastChannel(chatID int64) bool {
+	for _, c := range broadcastChannels {
+		if c.id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBroadcastChannels warns about channels the bot can't post to, it has to be a channel admin for that
+func (b *Bot) checkBroadcastChannels() {
+	for _, c := range broadcastChannels {
+		api := b.shardFor(c.String()).api
+		member, err := api.GetChatMember(tgbotapi.GetChatMemberConfig{ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
+			ChatID:             c.id,
+			SuperGroupUsername: c.username,
+			UserID:             api.Self.ID,
+		}})
+		if err != nil {
+			slog.Warn("Can't check broadcast channel, is the bot a member?", "channel", c, "err", err)
+			continue
+		}
+		if !member.IsAdministrator() && !member.IsCreator() {
+			slog.Warn("The bot is not an admin of the broadcast channel and can't post there", "channel", c, "bot", api.Self.UserName, "status", member.Status)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/wcharczuk/go-chart/v2"
+)
+
+// chartPeriod is how far back the samples for /chart are kept
+const chartPeriod = 24 * time.Hour
+
+// sample is one poll of a station, for /chart. Readings the inverter didn't report are nil.
+type sample struct {
+	at       time.Time
+	grid     int
+	pv, load *int
+}
+
+// recordSample keeps the readings of the last chartPeriod. Must be called with b.mu held.
+func (st *station) recordSample(at time.Time, response LuxpowerResponse) {
+	st.samples = append(st.samples, sample{at: at, grid: response.GridToLoad, pv: response.Ppv, load: response.Pload})
+
+	// Polls are in time order, so the expired ones are at the front
+	cutoff := at.Add(-chartPeriod)
+	i := 0
+	for i < len(st.samples) && st.samples[i].at.Before(cutoff) {
+		i++
+	}
+	st.samples = st.samples[i:]
+}
+
+// handleChartCommand sends a chart of grid import, solar and consumption over the last day for every station
+func (b *Bot) handleChartCommand(chatID int64) {
+	type stationChart struct {
+		st      *station // Only its name is read, which never changes
+		samples []sample
+	}
+
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	charts := make([]stationChart, 0, len(b.stations))
+	for _, st := range b.stations {
+		charts = append(charts, stationChart{st: st, samples: append([]sample(nil), st.samples...)})
+	}
+	b.mu.Unlock()
+
+	// Rendering takes a moment, so it's done outside the lock on copies of the samples
+	for _, c := range charts {
+		if len(c.samples) < 2 {
+			b.sendMessageToGroup(chatID, b.stationText(c.st, tr(lang, "chart_no_data")))
+			continue
+		}
+		png, err := renderChart(lang, c.st.name, c.samples)
+		if err != nil {
+			slog.Error("Error rendering chart", "station", c.st.id, "err", err)
+			b.sendMessageToGroup(chatID, b.stationText(c.st, tr(lang, "chart_failed")))
+			continue
+		}
+		b.sendFile(chatID, tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: "chart.png", Bytes: png}))
+	}
+}
+
+// renderChart draws the samples as a PNG with one line per reading
+func renderChart(lang, title string, samples []sample) ([]byte, error) {
+	grid := chart.TimeSeries{Name: tr(lang, "chart_grid")}
+	pv := chart.TimeSeries{Name: tr(lang, "chart_pv")}
+	load := chart.TimeSeries{Name: tr(lang, "chart_load")}
+	for _, s := range samples {
+		grid.XValues = append(grid.XValues, s.at)
+		grid.YValues = append(grid.YValues, float64(s.grid))
+		if s.pv != nil {
+			pv.XValues = append(pv.XValues, s.at)
+			pv.YValues = append(pv.YValues, float64(*s.pv))
+		}
+		if s.load != nil {
+			load.XValues = append(load.XValues, s.at)
+			load.YValues = append(load.YValues, float64(*s.load))
+		}
+	}
+
+	series := []chart.Series{grid}
+	for _, s := range []chart.TimeSeries{pv, load} {
+		if len(s.XValues) >= 2 { // go-chart can't draw a line from a single point
+			series = append(series, s)
+		}
+	}
+
+	graph := chart.Chart{
+		Title: title,
+		XAxis: chart.XAxis{ValueFormatter: func(v any) string {
+			if f, ok := v.(float64); ok {
+				return chart.TimeFromFloat64(f).In(location).Format("15:04")
+			}
+			return ""
+		}},
+		YAxis: chart.YAxis{Name: tr(lang, "chart_watts"), ValueFormatter: func(v any) string {
+			if f, ok := v.(float64); ok {
+				return strconv.Itoa(int(f))
+			}
+			return ""
+		}},
+		Series: series,
+	}
+	graph.Elements = []chart.Renderable{chart.Legend(&graph)}
+
+	var buf bytes.Buffer
+	if err := graph.Render(chart.PNG, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ChatInfo is what the bot knows about a chat it has seen
+type ChatInfo struct {
+	Subscribed bool       `json:"subscribed"`                       // False if the chat unsubscribed or never registered
+	Title      string     `json:"title,omitempty"`                  // Group or channel title, the user's name for a private chat
+	Type       string     `json:"type,omitempty"`                   // private, group, supergroup or channel
+	Thread     int        `json:"thread,omitempty"`                 // Forum topic notifications go to, set by /subscribe in a topic
+	Language   string     `json:"language,omitempty"`               // Chosen with /lang, defaultLanguage if empty
+	MuteUntil  time.Time  `json:"mute_until,omitzero"`              // Set by /mute, no notifications until then
+	Disabled   []category `json:"disabled_notifications,omitempty"` // Categories turned off with /notify
+}
+
+// UnmarshalJSON also accepts the bare subscribed flag that state files stored per chat before ChatInfo
+func (c *ChatInfo) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.Subscribed); err == nil {
+		return nil
+	}
+	type plain ChatInfo // Without this method, so it doesn't recurse
+	return json.Unmarshal(data, (*plain)(c))
+}
+
+// updateChat changes what the bot knows about a chat, adding it if it's new. Must be called with b.mu held.
+func (b *Bot) updateChat(chatID int64, update func(c *ChatInfo)) {
+	c := b.chats[chatID]
+	update(&c)
+	b.chats[chatID] = c
+}
+
+// rememberChat stores the title and type of a chat the bot has already seen, saving the state only
+// when they changed. Must be called with b.mu held.
+func (b *Bot) rememberChat(chat *tgbotapi.Chat) {
+	c, known := b.chats[chat.ID]
+	title := chatTitle(chat)
+	if !known || (c.Title == title && c.Type == chat.Type) {
+		return
+	}
+	c.Title, c.Type = title, chat.Type
+	b.chats[chat.ID] = c
+	b.saveState()
+}
+
+// removeChat drops a chat that unsubscribed from the state, along with its settings and undelivered
+// notifications. With AUTO_REGISTER its next message would subscribe it again, so then a bare
+// unsubscribed entry stays behind. Must be called with b.mu held.
+func (b *Bot) removeChat(chatID int64) {
+	delete(b.chats, chatID)
+	if autoRegister {
+		b.chats[chatID] = ChatInfo{}
+	}
+	b.pending = slices.DeleteFunc(b.pending, func(p pendingNotification) bool { return p.Chat == chatID && !p.isChannel() })
+	b.saveState()
+}
+
+// chatTitle is the title of a group or channel, or the name of the user for a private chat
+func chatTitle(chat *tgbotapi.Chat) string {
+	if chat.Title != "" {
+		return chat.Title
+	}
+	if chat.UserName != "" {
+		return "@" + chat.UserName
+	}
+	return strings.TrimSpace(chat.FirstName + " " + chat.LastName)
+}
@@ -0,0 +1,40 @@
+package main
+
+import "time"
+
+// Clock is the source of time for the state machine, quiet hours, stats and other time-dependent logic,
+// so tests can move time forward with a fake instead of sleeping
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Ticker is the part of *time.Ticker the bot uses
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Timer is the part of *time.Timer the bot uses
+type Timer interface {
+	Stop() bool
+}
+
+// clock is swapped for a fake in tests
+var clock Clock = realClock{}
+
+// realClock is the Clock backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// configFile holds the settings read from CONFIG_FILE, keyed by env var name. Set env vars take precedence.
+// configErr is reported by main, the file is read before logging is set up.
+var configFile, configErr = loadConfigFile(os.Getenv("CONFIG_FILE"))
+
+// secretFileErrs are the *_FILE settings getenv couldn't read, reported by validateConfig
+var secretFileErrs []error
+
+// loadConfigFile reads a JSON object of settings such as {"CHECK_INTERVAL": "2m", "GRID_DOWN_THRESHOLD": 50}.
+// Numbers and booleans are taken as written, lists (e.g. LUXPOWER_STATIONS, ADMIN_IDS) are joined with commas.
+func loadConfigFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	config := make(map[string]string, len(raw))
+	for key, value := range raw {
+		s, err := configValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s in %s: %w", key, path, err)
+		}
+		config[key] = s
+	}
+	return config, nil
+}
+
+// configValue renders a JSON value the way it would be written in an env var
+func configValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// validateConfig checks the settings the bot can't run without, listing every problem at once
+func validateConfig() error {
+	errs := secretFileErrs
+	if len(telegramBotTokens) == 0 {
+		errs = append(errs, errors.New("TELEGRAM_BOT_TOKEN is not set"))
+	}
+	if len(telegramBotTokens) > 1 && webhookURL != "" {
+		errs = append(errs, errors.New("WEBHOOK_URL works with a single bot, several TELEGRAM_BOT_TOKENS need long polling"))
+	}
+	if luxpowerAccount == "" {
+		errs = append(errs, errors.New("LUXPOWER_ACCOUNT is not set"))
+	}
+	if luxpowerPassword == "" {
+		errs = append(errs, errors.New("LUXPOWER_PASSWORD is not set"))
+	}
+
+	if telegramProxy != "" {
+		if u, err := url.Parse(telegramProxy); err != nil {
+			errs = append(errs, fmt.Errorf("TELEGRAM_PROXY is invalid: %w", err))
+		} else if !slices.Contains([]string{"http", "https", "socks5", "socks5h"}, u.Scheme) || u.Host == "" {
+			errs = append(errs, fmt.Errorf("TELEGRAM_PROXY must be an http://, https:// or socks5:// URL with a host, not %q", telegramProxy))
+		}
+	}
+	if apiAddr != "" && apiToken == "" {
+		errs = append(errs, errors.New("API_TOKEN must be set to serve API_ADDR"))
+	}
+	if parseModeErr != nil {
+		errs = append(errs, parseModeErr)
+	}
+
+	switch luxpowerClient {
+	case "binary":
+		// Resolved once here, so a binary found in $PATH isn't looked up on every poll
+		if path, err := exec.LookPath(luxpowerBinary); err != nil {
+			errs = append(errs, fmt.Errorf("LUXPOWER_BINARY is not usable: %w", err))
+		} else {
+			luxpowerBinary = path
+		}
+	case "http":
+		// go-luxpower picks the account's station by itself, the API needs it named
+		if luxpowerStation == "" && luxpowerStations == "" {
+			errs = append(errs, errors.New("LUXPOWER_STATION or LUXPOWER_STATIONS must be set, or LUXPOWER_CLIENT=binary to let go-luxpower pick the station"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("LUXPOWER_CLIENT must be \"binary\" or \"http\", not %q", luxpowerClient))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure Go, so the static build keeps working without cgo
+)
+
+// dbPruneInterval is how often readings older than DB_RETENTION_DAYS are deleted
+const dbPruneInterval = time.Hour
+
+// readingsDB keeps every poll in SQLite at DB_PATH for long-term analysis.
+// A nil readingsDB, without DB_PATH, stores nothing.
+type readingsDB struct {
+	db *sql.DB
+}
+
+// openReadingsDB opens or creates the database at path
+func openReadingsDB(path string) (*readingsDB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // SQLite allows one writer, queueing in database/sql beats "database is locked"
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS readings (
+			station TEXT NOT NULL,
+			at      INTEGER NOT NULL, -- Unix seconds
+			grid    INTEGER NOT NULL, -- GridToLoad, W
+			soc     INTEGER,          -- %, NULL if not reported
+			ppv     INTEGER,          -- W, NULL if not reported
+			pload   INTEGER           -- W, NULL if not reported
+		);
+		CREATE INDEX IF NOT EXISTS readings_at ON readings (at);
+	`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &readingsDB{db: db}, nil
+}
+
+// insert stores a poll of station
+func (d *readingsDB) insert(station string, at time.Time, r LuxpowerResponse) {
+	if d == nil {
+		return
+	}
+	if _, err := d.db.Exec("INSERT INTO readings (station, at, grid, soc, ppv, pload) VALUES (?, ?, ?, ?, ?, ?)",
+		station, at.Unix(), r.GridToLoad, r.SOC, r.Ppv, r.Pload); err != nil {
+		slog.Error("Error storing reading", "station", station, "err", err)
+	}
+}
+
+// since returns the readings of station from from on, oldest first
+func (d *readingsDB) since(station string, from time.Time) ([]sample, error) {
+	rows, err := d.db.Query("SELECT at, grid, ppv, pload FROM readings WHERE station = ? AND at >= ? ORDER BY at", station, from.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []sample
+	for rows.Next() {
+		var at int64
+		var s sample
+		if err := rows.Scan(&at, &s.grid, &s.pv, &s.load); err != nil {
+			return nil, err
+		}
+		s.at = time.Unix(at, 0)
+		samples = append(samples, s)
+	}
+	return samples, rows.Err()
+}
+
+// prune deletes the readings older than DB_RETENTION_DAYS
+func (d *readingsDB) prune(now time.Time) {
+	res, err := d.db.Exec("DELETE FROM readings WHERE at < ?", now.Add(-dbRetention).Unix())
+	if err != nil {
+		slog.Error("Error pruning readings", "err", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		slog.Info("Pruned old readings", "count", n)
+	}
+}
+
+// runPruning prunes the database now and every dbPruneInterval until ctx is cancelled
+func (d *readingsDB) runPruning(ctx context.Context) {
+	ticker := clock.NewTicker(dbPruneInterval)
+	defer ticker.Stop()
+	for {
+		d.prune(clock.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// restore fills the in-memory readings of st, for /chart and /forecast, from the database,
+// so they don't start empty after a restart
+func (d *readingsDB) restore(st *station, now time.Time) {
+	samples, err := d.since(st.polledID(), now.Add(-chartPeriod))
+	if err != nil {
+		slog.Error("Error loading readings", "station", st.polledID(), "err", err)
+		return
+	}
+	st.samples = samples
+	for _, s := range samples[max(len(samples)-loadSamples, 0):] {
+		if s.load != nil {
+			st.recentLoad = append(st.recentLoad, *s.load)
+		}
+	}
+}
+
+// close closes the database
+func (d *readingsDB) close() {
+	if d == nil {
+		return
+	}
+	if err := d.db.Close(); err != nil {
+		slog.Error("Error closing database", "err", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import "strings"
+
+// handleDiagCommand replies with a snapshot of the bot's health for troubleshooting from Telegram:
+// the last poll, the subscribers, the state machine of every station and the main settings.
+// The LuxPower password is only reported as set or not.
+func (b *Bot) handleDiagCommand(chatID int64) {
+	now := clock.Now()
+
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	var lines []string
+	if b.lastPollTime.IsZero() {
+		lines = append(lines, tr(lang, "diag_no_poll"))
+	} else {
+		lines = append(lines, tr(lang, "diag_poll", b.lastPollTime.In(location).Format("02.01 15:04:05"), formatDuration(lang, now.Sub(b.lastPollTime))))
+	}
+	if b.lastPollErr != nil {
+		lines = append(lines, tr(lang, "diag_poll_error", b.lastPollErr))
+	}
+
+	subscribers := 0
+	for id, c := range b.chats {
+		if c.Subscribed && !isBroadcastChannel(id) {
+			subscribers++
+		}
+	}
+	lines = append(lines, tr(lang, "diag_subscribers", subscribers, len(broadcastChannels), len(b.shards)))
+
+	var ids []string
+	for _, st := range b.stations {
+		recheck := tr(lang, "diag_recheck_none")
+		if st.recheckPending {
+			recheck = tr(lang, "diag_recheck_pending")
+		}
+		text := gridStateText(lang, st.previousGridState) + " " + tr(lang, "diag_station", st.gridPower, st.pollFailures, st.changeCount, confirmCount, recheck)
+		lines = append(lines, b.stationText(st, text))
+		id := st.id
+		if st.override != "" {
+			id += " → " + st.override
+		}
+		ids = append(ids, id)
+	}
+	lines = append(lines, tr(lang, "diag_config", b.checkInterval, b.recheckDelay, gridDownThreshold, confirmCount))
+	b.mu.Unlock()
+
+	password := tr(lang, "diag_password_unset")
+	if luxpowerPassword != "" {
+		password = tr(lang, "diag_password_set")
+	}
+	lines = append(lines, tr(lang, "diag_luxpower", luxpowerAccount, password, luxpowerClient, strings.Join(ids, ", ")))
+
+	b.sendMessageToGroup(chatID, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// fallbackTimeout bounds a fallback notification, it is sent when things are already going wrong
+const fallbackTimeout = 30 * time.Second
+
+// Notifier delivers a notification outside Telegram
+type Notifier interface {
+	Notify(ctx context.Context, text string) error
+}
+
+// fallbackNotifiers get the notifications Telegram couldn't deliver, see newFallbackNotifiers
+var fallbackNotifiers = newFallbackNotifiers()
+
+// newFallbackNotifiers returns a notifier for every configured channel: Pushover with PUSHOVER_TOKEN
+// and PUSHOVER_USER, email with SMTP_ADDR and SMTP_TO
+func newFallbackNotifiers() []Notifier {
+	var notifiers []Notifier
+	if pushoverToken != "" && pushoverUser != "" {
+		notifiers = append(notifiers, pushoverNotifier{token: pushoverToken, user: pushoverUser})
+	}
+	if smtpAddr != "" && smtpTo != "" {
+		notifiers = append(notifiers, smtpNotifier{addr: smtpAddr, username: smtpUsername, password: smtpPassword, from: cmp.Or(smtpFrom, smtpUsername), to: strings.FieldsFunc(smtpTo, func(r rune) bool { return r == ',' || r == ' ' })})
+	}
+	return notifiers
+}
+
+// sendFallback sends text through every fallback notifier in the background, so a slow
+// mail server doesn't hold up the bot
+func sendFallback(text string) {
+	if len(fallbackNotifiers) == 0 {
+		return
+	}
+	slog.Warn("Telegram is unreachable, sending the notification through the fallback channels")
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), fallbackTimeout)
+		defer cancel()
+		for _, n := range fallbackNotifiers {
+			if err := n.Notify(ctx, text); err != nil {
+				slog.Error("Error sending fallback notification", "notifier", fmt.Sprintf("%T", n), "err", err)
+			}
+		}
+	}()
+}
+
+// pushoverNotifier sends through the Pushover message API
+type pushoverNotifier struct {
+	token, user string
+}
+
+func (n pushoverNotifier) Notify(ctx context.Context, text string) error {
+	form := url.Values{"token": {n.token}, "user": {n.user}, "message": {text}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.pushover.net/1/messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("pushover: %s: %s", resp.Status, body)
+	}
+	return nil
+}
+
+// smtpNotifier sends an email. The server must offer STARTTLS when a username is set,
+// net/smtp refuses to send the password in the clear.
+type smtpNotifier struct {
+	addr               string // host:port
+	username, password string // Empty username sends without authentication
+	from               string
+	to                 []string
+}
+
+func (n smtpNotifier) Notify(ctx context.Context, text string) error {
+	host, _, err := net.SplitHostPort(n.addr)
+	if err != nil {
+		return fmt.Errorf("SMTP_ADDR: %w", err)
+	}
+	var auth smtp.Auth
+	if n.username != "" {
+		auth = smtp.PlainAuth("", n.username, n.password, host)
+	}
+
+	subject, _, _ := strings.Cut(text, "\n")
+	msg := "From: " + n.from + "\r\n" +
+		"To: " + strings.Join(n.to, ", ") + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + strings.ReplaceAll(text, "\n", "\r\n") + "\r\n"
+
+	// smtp.SendMail takes no context, so the deadline is only checked before sending
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return smtp.SendMail(n.addr, auth, n.from, n.to, []byte(msg))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// recordTransition notes a confirmed change of st at at and reports whether to announce it. More than
+// FLAP_COUNT changes within FLAP_WINDOW are announced once as the grid being unstable instead, and
+// further changes are held back until checkStable sees it settle. Must be called with b.mu held.
+func (b *Bot) recordTransition(st *station, at time.Time) bool {
+	if flapCount <= 0 {
+		return true
+	}
+
+	st.transitions = append(slices.DeleteFunc(st.transitions, func(t time.Time) bool { return at.Sub(t) >= flapWindow }), at)
+	if st.flapping {
+		return false
+	}
+	if len(st.transitions) <= flapCount {
+		return true
+	}
+
+	st.flapping = true
+	count := len(st.transitions)
+	slog.Warn("Grid is unstable, holding back its changes", "station", st.id, "changes", count, "window", flapWindow)
+	b.notify(categoryGrid, func(lang string) string {
+		return b.stationText(st, bold(tr(lang, "grid_unstable", count, formatDuration(lang, flapWindow))))
+	})
+	return false
+}
+
+// checkStable ends the unstable state of st once it has gone FLAP_WINDOW without a change,
+// announcing the state the grid settled in. Must be called with b.mu held.
+func (b *Bot) checkStable(st *station, now time.Time) {
+	if !st.flapping || now.Sub(st.transitions[len(st.transitions)-1]) < flapWindow {
+		return
+	}
+
+	st.flapping = false
+	st.transitions = nil
+	state := st.previousGridState
+	slog.Info("Grid is stable again", "station", st.id, "down", isGridDown(state))
+	b.notify(categoryGrid, func(lang string) string {
+		return b.stationText(st, tr(lang, "grid_stable")+"\n"+gridStateText(lang, state))
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadSamples is how many consumption readings the forecast averages, about an hour at the default CHECK_INTERVAL
+const loadSamples = 60
+
+// coordinates is where the panels are, for sunrise times
+type coordinates struct {
+	lat, lon float64 // Degrees, north and east positive
+}
+
+// parseCoordinates parses LATITUDE and LONGITUDE. It returns nil, meaning no forecast, if either is missing or invalid.
+func parseCoordinates(lat, lon string) *coordinates {
+	if lat == "" || lon == "" {
+		return nil
+	}
+
+	la, err1 := strconv.ParseFloat(lat, 64)
+	lo, err2 := strconv.ParseFloat(lon, 64)
+	if err1 != nil || err2 != nil || math.Abs(la) > 90 || math.Abs(lo) > 180 {
+		slog.Warn("Invalid coordinates, /forecast is disabled", "latitude", lat, "longitude", lon)
+		return nil
+	}
+	return &coordinates{lat: la, lon: lo}
+}
+
+// sunTimes returns sunrise and sunset on the local day of t, using the sunrise equation.
+// ok is false during polar day or night.
+func (c *coordinates) sunTimes(t time.Time) (sunrise, sunset time.Time, ok bool) {
+	const j2000 = 2451545.0 // Julian date of 2000-01-01 12:00 UTC
+	rad := math.Pi / 180
+
+	t = t.In(location)
+	noon := time.Date(t.Year(), t.Month(), t.Day(), 12, 0, 0, 0, location)
+	n := math.Round(julianDate(noon) - j2000 - 0.0008)
+
+	meanNoon := n - c.lon/360
+	anomaly := math.Mod(357.5291+0.98560028*meanNoon, 360)
+	center := 1.9148*math.Sin(anomaly*rad) + 0.02*math.Sin(2*anomaly*rad) + 0.0003*math.Sin(3*anomaly*rad)
+	longitude := math.Mod(anomaly+center+180+102.9372, 360)
+	transit := j2000 + meanNoon + 0.0053*math.Sin(anomaly*rad) - 0.0069*math.Sin(2*longitude*rad)
+
+	declination := math.Asin(math.Sin(longitude*rad) * math.Sin(23.4397*rad))
+	cosHourAngle := (math.Sin(-0.833*rad) - math.Sin(c.lat*rad)*math.Sin(declination)) / (math.Cos(c.lat*rad) * math.Cos(declination))
+	if math.Abs(cosHourAngle) > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	hourAngle := math.Acos(cosHourAngle) / rad
+
+	return fromJulianDate(transit - hourAngle/360), fromJulianDate(transit + hourAngle/360), true
+}
+
+func julianDate(t time.Time) float64 {
+	return float64(t.Unix())/86400 + 2440587.5
+}
+
+func fromJulianDate(jd float64) time.Time {
+	return time.Unix(int64((jd-2440587.5)*86400), 0).In(location)
+}
+
+// recordLoad keeps the last loadSamples consumption readings of st. Must be called with b.mu held.
+func (st *station) recordLoad() {
+	if st.pload == nil {
+		return
+	}
+	st.recentLoad = append(st.recentLoad, *st.pload)
+	if len(st.recentLoad) > loadSamples {
+		st.recentLoad = st.recentLoad[len(st.recentLoad)-loadSamples:]
+	}
+}
+
+// averageLoad is the mean of the recent consumption readings in W, ok is false without any
+func (st *station) averageLoad() (load float64, ok bool) {
+	if len(st.recentLoad) == 0 {
+		return 0, false
+	}
+	sum := 0
+	for _, l := range st.recentLoad {
+		sum += l
+	}
+	return float64(sum) / float64(len(st.recentLoad)), true
+}
+
+func (b *Bot) handleForecastCommand(chatID int64) {
+	b.mu.Lock()
+	text := b.forecastText(b.chatLanguage(chatID), clock.Now())
+	b.mu.Unlock()
+
+	b.sendMessageToGroup(chatID, text)
+}
+
+// forecastText estimates whether the battery lasts until the sun is up again, assuming the recent
+// average consumption and no solar until sunrise. Must be called with b.mu held.
+func (b *Bot) forecastText(lang string, now time.Time) string {
+	if sunCoordinates == nil || batteryCapacityWh <= 0 {
+		return tr(lang, "forecast_not_configured")
+	}
+
+	sunrise, sunset, ok := sunCoordinates.sunTimes(now)
+	if !ok {
+		return tr(lang, "forecast_polar")
+	}
+	if now.After(sunrise) && now.Before(sunset) {
+		return tr(lang, "forecast_daytime", sunset.Format("15:04"))
+	}
+	if !now.Before(sunset) { // Evening, the next sunrise is tomorrow's
+		if sunrise, _, ok = sunCoordinates.sunTimes(now.AddDate(0, 0, 1)); !ok {
+			return tr(lang, "forecast_polar")
+		}
+	}
+
+	lines := make([]string, 0, len(b.stations))
+	for _, st := range b.stations {
+		load, ok := st.averageLoad()
+		if !ok || st.soc == nil {
+			lines = append(lines, b.stationText(st, tr(lang, "forecast_no_data")))
+			continue
+		}
+
+		remaining := float64(*st.soc) / 100 * float64(batteryCapacityWh) // Wh
+		if remaining >= load*sunrise.Sub(now).Hours() {
+			lines = append(lines, b.stationText(st, tr(lang, "forecast_enough", sunrise.Format("15:04"))))
+			continue
+		}
+		depleted := now.Add(time.Duration(remaining / load * float64(time.Hour))).In(location)
+		lines = append(lines, b.stationText(st, tr(lang, "forecast_depleted", depleted.Format("15:04"), sunrise.Format("15:04"))))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// recordPoll remembers the outcome of a poll for the health check. Must be called with b.mu held.
+func (b *Bot) recordPoll(err error) {
+	b.lastPollErr = err
+	if err == nil {
+		b.lastPollTime = clock.Now()
+	}
+}
+
+// checkPollFailures alerts the admins once when polling st has failed POLL_FAILURE_ALERT times in a row,
+// and again when it recovers. Must be called with b.mu held.
+func (b *Bot) checkPollFailures(st *station, err error) {
+	if err == nil {
+		st.pollFailures = 0
+		if st.pollAlerted {
+			st.pollAlerted = false
+			slog.Info("Polling recovered", "station", st.id)
+			b.sendToAdmins(func(lang string) string {
+				return b.stationText(st, tr(lang, "poll_recovered"))
+			})
+		}
+		return
+	}
+
+	st.pollFailures++
+	if pollFailureAlert <= 0 || st.pollFailures < pollFailureAlert || st.pollAlerted {
+		return
+	}
+
+	st.pollAlerted = true
+	slog.Warn("Polling keeps failing, alerting admins", "station", st.id, "failures", st.pollFailures)
+	b.sendToAdmins(func(lang string) string {
+		return b.stationText(st, tr(lang, "poll_failing", st.pollFailures, err))
+	})
+}
+
+// checkStaleData alerts the admins once when st has reported the same readings for STALE_DATA_THRESHOLD,
+// which happens when the inverter is offline and the LuxPower cloud keeps serving its last data, and again
+// when they change. go-luxpower doesn't say how old a reading is, so this is the only sign of it.
+// Must be called with b.mu held.
+func (b *Bot) checkStaleData(st *station, response LuxpowerResponse) {
+	now := clock.Now()
+	if !sameReading(response, st.lastReading) || st.readingSince.IsZero() {
+		st.lastReading = response
+		st.readingSince = now
+		if st.staleAlerted {
+			st.staleAlerted = false
+			slog.Info("Inverter data updates again", "station", st.id)
+			b.sendToAdmins(func(lang string) string {
+				return b.stationText(st, tr(lang, "stale_data_recovered"))
+			})
+		}
+		return
+	}
+
+	// GridToLoad alone stays at 0 for a whole outage, only consumption or PV readings change all the time
+	if staleDataThreshold <= 0 || (response.Pload == nil && response.Ppv == nil) || st.staleAlerted {
+		return
+	}
+	if age := now.Sub(st.readingSince); age >= staleDataThreshold {
+		st.staleAlerted = true
+		slog.Warn("Inverter data looks stale, the readings haven't changed", "station", st.id, "since", st.readingSince)
+		b.sendToAdmins(func(lang string) string {
+			return b.stationText(st, tr(lang, "stale_data", formatDuration(lang, age)))
+		})
+	}
+}
+
+// sameReading reports whether two readings have the same values
+func sameReading(a, b LuxpowerResponse) bool {
+	return a.GridToLoad == b.GridToLoad && equalPtr(a.SOC, b.SOC) && equalPtr(a.Ppv, b.Ppv) && equalPtr(a.Pload, b.Pload)
+}
+
+// equalPtr reports whether both are nil or point to equal values
+func equalPtr(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// sendToAdmins sends a message to the private chats of ADMIN_IDS. The alerts come from the middle of
+// a poll, so they are rendered with b.mu held and sent in the background, see sendAsync.
+// Must be called with b.mu held.
+func (b *Bot) sendToAdmins(render func(lang string) string) {
+	if len(adminIDs) == 0 {
+		slog.Warn("No ADMIN_IDS to alert")
+		return
+	}
+	// A user's private chat has the user's ID, it works once they have started the bot. With several
+	// bots the one they last wrote to sends it, see claimChat.
+	texts := make(map[int64]string, len(adminIDs))
+	for id := range adminIDs {
+		texts[id] = render(b.chatLanguage(id))
+	}
+	b.sendAsync(func() {
+		for id, text := range texts {
+			b.sendMessageToGroup(id, text)
+		}
+	})
+}
+
+// serveHealth exposes /healthz on addr. It only returns if the server fails.
+func (b *Bot) serveHealth(addr string, checkInterval time.Duration) {
+	started := clock.Now() // Healthy until the first poll is due
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		b.mu.Lock()
+		lastPoll, lastErr := b.lastPollTime, b.lastPollErr
+		b.mu.Unlock()
+
+		if lastPoll.Before(started) {
+			lastPoll = started
+		}
+		if clock.Now().Sub(lastPoll) <= 2*checkInterval {
+			fmt.Fprintln(w, "ok")
+			return
+		}
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "no successful poll since %s\n", lastPoll.Format(time.RFC3339))
+		if lastErr != nil {
+			fmt.Fprintf(w, "last error: %v\n", lastErr)
+		}
+	})
+
+	slog.Info("Serving health check", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("Health check server failed", "err", err)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+)
+
+const defaultLanguage = "uk"
+
+// messages is the catalog of user-facing texts, per language. Every key must exist in defaultLanguage.
+var messages = map[string]map[string]string{
+	"uk": {
+		"grid_down":               "Стан змінився: світла немає.",
+		"grid_up":                 "Стан змінився: світло є.",
+		"outage_lasted":           "Світла не було: %s",
+		"state_down":              "Світла немає.",
+		"state_up":                "Світло є.",
+		"state_unknown":           "Стан ще невідомий, зачекайте хвилину.",
+		"grid_unstable":           "Світло нестабільне: %d перемикань за %s. Окремі сповіщення призупинено, доки не стабілізується.",
+		"grid_stable":             "Світло стабілізувалось.",
+		"restarted":               "Бот перезапущено. Поточний стан:",
+		"fetch_failed":            "Не вдалося отримати дані з інвертора.",
+		"battery":                 "Батарея: %s\nСонячні панелі: %s\nСпоживання: %s",
+		"not_available":           "н/д",
+		"grid_power":              "Споживання з мережі: %d Вт",
+		"grid_power_unknown":      "Споживання з мережі ще невідоме.",
+		"quiet_summary":           "Поки діяли тихі години:",
+		"quiet_now":               "Зараз:",
+		"pong":                    "pong, %s",
+		"pong_last_poll":          "Останнє успішне опитування LuxPower: %s тому.",
+		"pong_no_poll":            "Успішних опитувань LuxPower ще не було.",
+		"uptime_bot":              "Бот працює %s.",
+		"uptime_up":               "Світло є вже %s.",
+		"uptime_down":             "Світла немає вже %s.",
+		"uptime_up_unknown":       "Світло є щонайменше %s (з запуску бота).",
+		"uptime_down_unknown":     "Світла немає щонайменше %s (з запуску бота).",
+		"grid_import_alert":       "Споживання з мережі перевищило %d Вт: зараз %d Вт.",
+		"battery_low":             "Світла немає, а батарея сідає: заряд %d%%.",
+		"poll_failing":            "Не вдається отримати дані з LuxPower %d разів поспіль, стан світла не відстежується.\nОстання помилка: %v",
+		"poll_recovered":          "Дані з LuxPower знову надходять, стан світла відстежується.",
+		"stale_data":              "Дані з інвертора застаріли: показники не змінюються вже %s. Інвертор, ймовірно, не на зв'язку, стан світла може бути неточним.",
+		"stale_data_recovered":    "Дані з інвертора знову оновлюються.",
+		"forecast_enough":         "Оцінка: батареї, ймовірно, вистачить до ранку (схід сонця о %s).",
+		"forecast_depleted":       "Оцінка: батарея, ймовірно, сяде близько %s, до сходу сонця о %s.",
+		"forecast_daytime":        "Зараз день, сонце сяде о %s.",
+		"forecast_polar":          "Сьогодні сонце не сходить або не заходить, оцінки немає.",
+		"forecast_no_data":        "Ще немає даних про заряд і споживання.",
+		"forecast_not_configured": "Для прогнозу потрібні LATITUDE, LONGITUDE і BATTERY_CAPACITY_WH.",
+		"battery_soc":             "Батарея: %d%%",
+		"battery_runtime":         "Батареї має вистачити приблизно на %s (оцінка).",
+		"battery_charging":        "Сонце покриває споживання, батарея заряджається.",
+		"watts":                   "%d Вт",
+		"hours_minutes":           "%d год %d хв",
+		"minutes":                 "%d хв",
+		"days_hours":              "%d дн %d год",
+		"stats":                   "Сьогодні відключень: %d\nБез світла: %s\nНайдовше: %s",
+		"stats_none":              "Сьогодні відключень не було.",
+		"reliability_today":       "%.1f%% за сьогодні (сумарно %s без світла)",
+		"reliability_week":        "%.1f%% за 7 днів (сумарно %s без світла)",
+		"reliability_month":       "%.1f%% за 30 днів (сумарно %s без світла)",
+		"reliability_partial":     "— виміряно лише за %s",
+		"summary":                 "Підсумок за %s",
+		"next_summary":            "Наступний підсумок дня: %s (%s). Якби його надіслали зараз, він був би таким:",
+		"next_summary_off":        "Підсумок дня вимкнено, його вмикає DAILY_SUMMARY_AT.",
+		"next_summary_disabled":   "У цьому чаті підсумки вимкнено через /notify.",
+		"next_summary_skip":       "Поки відключень не було, тож без DAILY_SUMMARY_ALWAYS його не надішлють.",
+		"summary_outages":         "Відключень: %d, без світла: %s",
+		"summary_none":            "Відключень не було.",
+		"history_entry":           "%s – %s (%s)",
+		"history_ongoing":         "%s – триває (%s)",
+		"history_none":            "Відключень ще не було.",
+		"export_none":             "Немає даних: відключень ще не було.",
+		"chart_no_data":           "Для графіка ще замало даних.",
+		"chart_failed":            "Не вдалося намалювати графік.",
+		"chart_grid":              "З мережі",
+		"chart_pv":                "Сонце",
+		"chart_load":              "Споживання",
+		"chart_watts":             "Вт",
+		"subscribed":              "Сповіщення увімкнено.",
+		"unsubscribed":            "Сповіщення вимкнено. Щоб увімкнути знову, надішліть /subscribe.",
+		"language_set":            "Мову змінено на українську.",
+		"language_usage":          "Використання: /lang uk або /lang en",
+		"not_allowed":             "Недостатньо прав.",
+		"muted":                   "Сповіщення призупинено до %s. /unmute, щоб увімкнути раніше.",
+		"unmuted":                 "Сповіщення знову увімкнено.",
+		"mute_usage":              "Використання: /mute 2h або /mute 30m",
+		"test_notification":       "Це тестове повідомлення (%s).",
+		"delayed_notification":    "(Надіслано із запізненням. Час події: %s.)",
+		"cooldown":                "Зачекайте %d с і спробуйте ще раз.",
+		"version":                 "Версія: %s\nКоміт: %s\nЗібрано: %s",
+		"id_chat":                 "ID чату: %d",
+		"subscribers":             "Сповіщення отримують чатів: %d",
+		"subscribers_muted":       "(вимкнено до %s)",
+		"subscribers_channel":     "(канал)",
+		"id_user":                 "Ваш ID: %d",
+		"notify_list":             "Сповіщення в цьому чаті:",
+		"notify_line":             "%s (%s): %s",
+		"notify_on":               "увімкнено",
+		"notify_off":              "вимкнено",
+		"notify_usage":            "Змінити: /notify <категорія> on або off, напр. /notify battery off",
+		"diag_poll":               "Останнє успішне опитування: %s (%s тому)",
+		"diag_no_poll":            "Успішних опитувань ще не було.",
+		"diag_poll_error":         "Останнє опитування не вдалося: %v",
+		"diag_subscribers":        "Чатів зі сповіщеннями: %d, каналів: %d, ботів: %d",
+		"diag_station":            "Мережа: %d Вт. Невдалих опитувань поспіль: %d. Змінених показів поспіль: %d з %d. Повторна перевірка: %s.",
+		"diag_recheck_pending":    "запланована",
+		"diag_recheck_none":       "ні",
+		"diag_config":             "Опитування кожні %s, повторна перевірка через %s, поріг мережі %d Вт, підтверджень %d.",
+		"diag_luxpower":           "LuxPower: акаунт %s, пароль %s, клієнт %s, станції %s.",
+		"diag_password_set":       "задано",
+		"diag_password_unset":     "не задано",
+		"setstation_usage":        "Використання: /setstation <ID станції> або /setstation default. Якщо станцій кілька: /setstation <назва> <ID>",
+		"setstation_unknown":      "Немає станції %s.",
+		"setstation_failed":       "Не вдалося опитати станцію %s, залишаю поточну: %v",
+		"setstation_done":         "Тепер відстежується станція %s. %s",
+		"setstation_default":      "Знову відстежується станція з налаштувань (%s).",
+		"notify_category_grid":    "відключення і повернення світла",
+		"notify_category_battery": "низький заряд батареї",
+		"notify_category_summary": "щоденний підсумок",
+		"notify_category_import":  "велике споживання з мережі",
+		"button_status":           "Статус",
+		"button_battery":          "Батарея",
+		"button_history":          "Історія",
+	},
+	"en": {
+		"grid_down":               "Status changed: the grid is down.",
+		"grid_up":                 "Status changed: the grid is back.",
+		"outage_lasted":           "The outage lasted %s",
+		"state_down":              "The grid is down.",
+		"state_up":                "The grid is up.",
+		"state_unknown":           "The state is not known yet, please wait a minute.",
+		"grid_unstable":           "The grid is unstable: %d changes within %s. Single changes won't be reported until it settles.",
+		"grid_stable":             "The grid has settled.",
+		"restarted":               "The bot restarted. Current state:",
+		"fetch_failed":            "Couldn't get data from the inverter.",
+		"battery":                 "Battery: %s\nSolar: %s\nConsumption: %s",
+		"not_available":           "n/a",
+		"grid_power":              "Grid import: %d W",
+		"grid_power_unknown":      "Grid import is not known yet.",
+		"quiet_summary":           "During quiet hours:",
+		"quiet_now":               "Now:",
+		"pong":                    "pong, %s",
+		"pong_last_poll":          "Last successful LuxPower poll: %s ago.",
+		"pong_no_poll":            "No successful LuxPower poll yet.",
+		"uptime_bot":              "The bot has been running for %s.",
+		"uptime_up":               "The grid has been up for %s.",
+		"uptime_down":             "The grid has been down for %s.",
+		"uptime_up_unknown":       "The grid has been up for at least %s (since the bot started).",
+		"uptime_down_unknown":     "The grid has been down for at least %s (since the bot started).",
+		"grid_import_alert":       "Grid import is above %d W: %d W now.",
+		"battery_low":             "The grid is down and the battery is running low: %d%% left.",
+		"poll_failing":            "Polling LuxPower failed %d times in a row, the grid is not being monitored.\nLast error: %v",
+		"poll_recovered":          "Polling LuxPower works again, the grid is being monitored.",
+		"stale_data":              "The inverter data is stale: the readings haven't changed for %s. The inverter is probably offline, the grid state may be wrong.",
+		"stale_data_recovered":    "The inverter data updates again.",
+		"forecast_enough":         "Estimate: the battery should last until morning (sunrise at %s).",
+		"forecast_depleted":       "Estimate: the battery will likely run out around %s, before sunrise at %s.",
+		"forecast_daytime":        "It's daytime, sunset is at %s.",
+		"forecast_polar":          "The sun doesn't rise or set today, no estimate.",
+		"forecast_no_data":        "No charge and consumption readings yet.",
+		"forecast_not_configured": "The forecast needs LATITUDE, LONGITUDE and BATTERY_CAPACITY_WH.",
+		"battery_soc":             "Battery: %d%%",
+		"battery_runtime":         "The battery should last about %s (estimate).",
+		"battery_charging":        "Solar covers the load, the battery is charging.",
+		"watts":                   "%d W",
+		"hours_minutes":           "%d h %d min",
+		"minutes":                 "%d min",
+		"days_hours":              "%d d %d h",
+		"stats":                   "Outages today: %d\nWithout grid: %s\nLongest: %s",
+		"stats_none":              "No outages today.",
+		"reliability_today":       "%.1f%% today (%s without grid in total)",
+		"reliability_week":        "%.1f%% over 7 days (%s without grid in total)",
+		"reliability_month":       "%.1f%% over 30 days (%s without grid in total)",
+		"reliability_partial":     "— measured over %s only",
+		"summary":                 "Summary for %s",
+		"next_summary":            "Next daily summary: %s (%s). Sent now, it would read:",
+		"next_summary_off":        "The daily summary is off, DAILY_SUMMARY_AT turns it on.",
+		"next_summary_disabled":   "Summaries are turned off in this chat with /notify.",
+		"next_summary_skip":       "There were no outages so far, so without DAILY_SUMMARY_ALWAYS it won't be sent.",
+		"summary_outages":         "Outages: %d, without grid: %s",
+		"summary_none":            "No outages.",
+		"history_entry":           "%s – %s (%s)",
+		"history_ongoing":         "%s – ongoing (%s)",
+		"history_none":            "No outages recorded yet.",
+		"export_none":             "No data: no outages recorded yet.",
+		"chart_no_data":           "Not enough readings for a chart yet.",
+		"chart_failed":            "Couldn't draw the chart.",
+		"chart_grid":              "Grid",
+		"chart_pv":                "Solar",
+		"chart_load":              "Consumption",
+		"chart_watts":             "W",
+		"subscribed":              "Notifications are on.",
+		"unsubscribed":            "Notifications are off. Send /subscribe to turn them back on.",
+		"language_set":            "Language set to English.",
+		"language_usage":          "Usage: /lang uk or /lang en",
+		"not_allowed":             "You are not allowed to do that.",
+		"muted":                   "Notifications are paused until %s. Send /unmute to resume earlier.",
+		"unmuted":                 "Notifications are back on.",
+		"mute_usage":              "Usage: /mute 2h or /mute 30m",
+		"test_notification":       "This is a test notification (%s).",
+		"delayed_notification":    "(Delivered late. Time of the event: %s.)",
+		"cooldown":                "Please wait %d s and try again.",
+		"version":                 "Version: %s\nCommit: %s\nBuilt: %s",
+		"id_chat":                 "Chat ID: %d",
+		"subscribers":             "Chats getting notifications: %d",
+		"subscribers_muted":       "(muted until %s)",
+		"subscribers_channel":     "(channel)",
+		"id_user":                 "Your user ID: %d",
+		"notify_list":             "Notifications in this chat:",
+		"notify_line":             "%s (%s): %s",
+		"notify_on":               "on",
+		"notify_off":              "off",
+		"notify_usage":            "To change: /notify <category> on or off, e.g. /notify battery off",
+		"diag_poll":               "Last successful poll: %s (%s ago)",
+		"diag_no_poll":            "No successful poll yet.",
+		"diag_poll_error":         "The last poll failed: %v",
+		"diag_subscribers":        "Chats with notifications: %d, channels: %d, bots: %d",
+		"diag_station":            "Grid: %d W. Failed polls in a row: %d. Changed readings in a row: %d of %d. Recheck: %s.",
+		"diag_recheck_pending":    "scheduled",
+		"diag_recheck_none":       "no",
+		"diag_config":             "Polling every %s, recheck after %s, grid threshold %d W, confirmations %d.",
+		"diag_luxpower":           "LuxPower: account %s, password %s, client %s, stations %s.",
+		"diag_password_set":       "set",
+		"diag_password_unset":     "not set",
+		"setstation_usage":        "Usage: /setstation <station ID> or /setstation default. With several stations: /setstation <name> <ID>",
+		"setstation_unknown":      "There is no station %s.",
+		"setstation_failed":       "Couldn't poll station %s, keeping the current one: %v",
+		"setstation_done":         "Now monitoring station %s. %s",
+		"setstation_default":      "Monitoring the configured station again (%s).",
+		"notify_category_grid":    "outages and restores",
+		"notify_category_battery": "low battery",
+		"notify_category_summary": "daily summary",
+		"notify_category_import":  "high grid import",
+		"button_status":           "Status",
+		"button_battery":          "Battery",
+		"button_history":          "History",
+	},
+}
+
+// commandHelp describes the commands listed by /help, per language. Keep it in sync when adding commands.
+var commandHelp = map[string]map[string]string{
+	"uk": {
+		"status":       "чи є зараз світло",
+		"battery":      "заряд батареї, сонячна генерація та споживання",
+		"grid":         "скільки зараз береться з мережі",
+		"uptime":       "скільки працює бот і як довго триває поточний стан",
+		"forecast":     "чи вистачить батареї до ранку (оцінка)",
+		"ping":         "перевірити, чи бот відповідає",
+		"version":      "версія бота",
+		"id":           "ID цього чату і ваш, для ADMIN_IDS і BROADCAST_CHANNELS",
+		"stats":        "відключення за сьогодні",
+		"reliability":  "скільки часу було світло: за сьогодні, 7 і 30 днів",
+		"next_summary": "коли буде наступний підсумок дня і що в ньому",
+		"history":      "останні відключення",
+		"export":       "усі збережені відключення файлом CSV",
+		"chart":        "графік мережі, сонця і споживання за добу",
+		"subscribe":    "увімкнути сповіщення в цьому чаті",
+		"register":     "додати цей чат до сповіщень",
+		"test":         "надіслати тестове сповіщення в усі чати",
+		"subscribers":  "чати, які отримують сповіщення",
+		"raw":          "відповідь LuxPower як є, для налагодження",
+		"diag":         "стан бота для діагностики",
+		"setstation":   "тимчасово відстежувати іншу станцію: /setstation <ID> або default",
+		"unsubscribe":  "вимкнути сповіщення в цьому чаті",
+		"lang":         "мова повідомлень: /lang uk або /lang en",
+		"mute":         "призупинити сповіщення, напр. /mute 2h",
+		"unmute":       "відновити сповіщення",
+		"notify":       "які сповіщення надсилати в цей чат",
+		"help":         "список команд",
+	},
+	"en": {
+		"status":       "whether the grid is up right now",
+		"battery":      "battery charge, solar production and consumption",
+		"grid":         "how much power is drawn from the grid",
+		"uptime":       "how long the bot has been running and the grid in its current state",
+		"forecast":     "whether the battery lasts until sunrise (estimate)",
+		"ping":         "check that the bot responds",
+		"version":      "bot version",
+		"id":           "IDs of this chat and of you, for ADMIN_IDS and BROADCAST_CHANNELS",
+		"stats":        "today's outages",
+		"reliability":  "share of time the grid was up today, over 7 and 30 days",
+		"next_summary": "when the next daily summary is due and what it says",
+		"history":      "recent outages",
+		"export":       "all retained outages as a CSV file",
+		"chart":        "chart of grid, solar and consumption over the last day",
+		"subscribe":    "turn notifications on in this chat",
+		"register":     "add this chat to the notifications",
+		"test":         "send a test notification to all chats",
+		"subscribers":  "chats that get notifications",
+		"raw":          "the LuxPower response as it is, for debugging",
+		"diag":         "the bot's health, for troubleshooting",
+		"setstation":   "monitor another station for a while: /setstation <ID> or default",
+		"unsubscribe":  "turn notifications off in this chat",
+		"lang":         "message language: /lang uk or /lang en",
+		"mute":         "pause notifications, e.g. /mute 2h",
+		"unmute":       "resume notifications",
+		"notify":       "choose which notifications this chat gets",
+		"help":         "list of commands",
+	},
+}
+
+// tr renders the message key in lang, falling back to the default language
+func tr(lang, key string, args ...any) string {
+	text, ok := messages[lang][key]
+	if !ok {
+		text = messages[defaultLanguage][key]
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// chatLanguage returns the language chosen for a chat. Must be called with b.mu held.
+func (b *Bot) chatLanguage(chatID int64) string {
+	return cmp.Or(b.chats[chatID].Language, defaultLanguage)
+}
+
+func (b *Bot) handleLangCommand(chatID int64, lang string) {
+	if _, ok := messages[lang]; !ok {
+		b.mu.Lock()
+		current := b.chatLanguage(chatID)
+		b.mu.Unlock()
+		b.sendMessageToGroup(chatID, tr(current, "language_usage"))
+		return
+	}
+
+	b.mu.Lock()
+	b.updateChat(chatID, func(c *ChatInfo) { c.Language = lang })
+	b.saveState()
+	b.mu.Unlock()
+
+	b.sendMessageToGroup(chatID, tr(lang, "language_set"))
+}
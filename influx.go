@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	influxFlushInterval = 5 * time.Minute // Readings are written in batches this often
+	influxMaxLines      = 10000           // Lines kept while the database is unreachable, the oldest are dropped first
+)
+
+// influxWriter pushes every reading to INFLUX_URL in the InfluxDB line protocol, which VictoriaMetrics
+// understands too. A nil writer, without INFLUX_URL, does nothing.
+type influxWriter struct {
+	url   string // The full write URL
+	token string
+
+	mu    sync.Mutex
+	lines []string // Not yet written, oldest first
+}
+
+// newInfluxWriter returns the writer for the InfluxDB v2 write API at INFLUX_URL, nil if it isn't set
+func newInfluxWriter() *influxWriter {
+	if influxURL == "" {
+		return nil
+	}
+	query := url.Values{"bucket": {influxBucket}, "precision": {"s"}}
+	if influxOrg != "" {
+		query.Set("org", influxOrg)
+	}
+	return &influxWriter{url: strings.TrimSuffix(influxURL, "/") + "/api/v2/write?" + query.Encode(), token: influxToken}
+}
+
+// add queues a reading of st as a "luxpower" point tagged with the station. Readings the inverter
+// didn't report are left out.
+func (w *influxWriter) add(st *station, at time.Time, r LuxpowerResponse) {
+	if w == nil {
+		return
+	}
+
+	// Empty tag values are invalid, so tags of an unnamed or unnumbered station are left out
+	var sb strings.Builder
+	sb.WriteString("luxpower")
+	if id := st.polledID(); id != "" {
+		sb.WriteString(",station=" + escapeInfluxTag(id))
+	}
+	if st.name != "" {
+		sb.WriteString(",name=" + escapeInfluxTag(st.name))
+	}
+	sb.WriteString(" grid=" + strconv.Itoa(r.GridToLoad) + "i")
+	for _, f := range []struct {
+		key   string
+		value *int
+	}{{"soc", r.SOC}, {"ppv", r.Ppv}, {"pload", r.Pload}} {
+		if f.value != nil {
+			sb.WriteString("," + f.key + "=" + strconv.Itoa(*f.value) + "i")
+		}
+	}
+	sb.WriteString(" " + strconv.FormatInt(at.Unix(), 10))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lines = append(w.lines, sb.String())
+	if len(w.lines) > influxMaxLines {
+		w.lines = w.lines[len(w.lines)-influxMaxLines:]
+	}
+}
+
+// escapeInfluxTag escapes the characters the line protocol gives a meaning in tag keys and values
+var escapeInfluxTag = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace
+
+// run writes the queued readings every influxFlushInterval until ctx is cancelled
+func (w *influxWriter) run(ctx context.Context) {
+	ticker := clock.NewTicker(influxFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			w.flush()
+		}
+	}
+}
+
+// flush writes the queued readings in one request. On failure they are kept for the next flush.
+func (w *influxWriter) flush() {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	lines := w.lines
+	w.lines = nil
+	w.mu.Unlock()
+	if len(lines) == 0 {
+		return
+	}
+
+	if err := w.write(lines); err != nil {
+		slog.Error("Error writing readings to InfluxDB, will retry", "count", len(lines), "err", err)
+		w.mu.Lock()
+		w.lines = append(lines, w.lines...)
+		if len(w.lines) > influxMaxLines {
+			w.lines = w.lines[len(w.lines)-influxMaxLines:]
+		}
+		w.mu.Unlock()
+		return
+	}
+	slog.Debug("Wrote readings to InfluxDB", "count", len(lines))
+}
+
+// write makes a single write request
+func (w *influxWriter) write(lines []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return nil
+}
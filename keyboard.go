@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log/slog"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// commandKeyboard offers the everyday commands as buttons, so nobody has to remember them.
+// The callback data is the command name.
+func commandKeyboard(lang string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(tr(lang, "button_status"), "status"),
+		tgbotapi.NewInlineKeyboardButtonData(tr(lang, "button_battery"), "battery"),
+		tgbotapi.NewInlineKeyboardButtonData(tr(lang, "button_history"), "history"),
+	))
+}
+
+// handleCallbackQuery acknowledges a pressed inline button and runs the command in its data,
+// the same way as if it was typed
+func (b *Bot) handleCallbackQuery(query *tgbotapi.CallbackQuery, sh *shard) {
+	slog.Debug("Callback query received", "data", query.Data)
+
+	// Stops the loading spinner on the button
+	if _, err := sh.api.Request(tgbotapi.NewCallback(query.ID, "")); err != nil {
+		slog.Error("Error answering callback query", "err", err)
+	}
+	if query.Message == nil || query.Message.Chat == nil { // The message is too old or was sent inline
+		return
+	}
+
+	b.runCommand(query.Message.Chat.ID, 0, query.From, query.Data, "")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io"
+	"log"
+	"log/slog"
+	"os"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Log file settings. Without LOG_FILE the log goes to stderr, for docker and journald.
+var (
+	logFile       = getenv("LOG_FILE", "")
+	logMaxSizeMB  = max(getenvInt("LOG_MAX_SIZE_MB", 10), 1)  // The file is rotated when it grows past this
+	logMaxAgeDays = max(getenvInt("LOG_MAX_AGE_DAYS", 30), 0) // Rotated files older than this are deleted, 0 keeps them
+	logMaxBackups = max(getenvInt("LOG_MAX_BACKUPS", 5), 0)   // Rotated files kept, 0 keeps all
+)
+
+// setupLogging sends the log, including TELEGRAM_DEBUG output, to LOG_FILE or stderr
+func setupLogging(level slog.Level) {
+	var w io.Writer = os.Stderr
+	if logFile != "" {
+		w = &lumberjack.Logger{
+			Filename:   logFile,
+			MaxSize:    logMaxSizeMB,
+			MaxAge:     logMaxAgeDays,
+			MaxBackups: logMaxBackups,
+			Compress:   true,
+		}
+		tgbotapi.SetLogger(log.New(w, "", log.LstdFlags))
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})))
+}
@@ -0,0 +1,206 @@
+// Package luxpower is a small client for the LuxPower web monitoring API,
+// the same API the go-luxpower tool and the LuxPower website use.
+package luxpower
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionTTL is how long a session is trusted without use. The server expires idle
+// sessions on its own, this just avoids a failed request when we know it's stale.
+const sessionTTL = 30 * time.Minute
+
+// ErrUnauthorized is returned when the API rejects the session or the credentials
+var ErrUnauthorized = errors.New("luxpower: not logged in")
+
+// Live is a snapshot of the inverter readings
+type Live struct {
+	GridToLoad int  // Power imported from the grid, W
+	SOC        *int // Battery state of charge, %; nil when not reported
+	Ppv        *int // Solar production, W; nil when not reported
+	Pload      *int // Consumption, W; nil when not reported
+}
+
+// Client talks to the LuxPower API for one station. It logs in on first use and
+// keeps the session cookie for later calls, logging in again only when the session
+// expires. It is safe for concurrent use.
+type Client struct {
+	baseURL  string
+	account  string
+	password string
+	station  string
+	http     *http.Client
+
+	mu             sync.Mutex
+	sessionExpires time.Time // Zero when not logged in
+	serial         string    // Serial number of the station's inverter, looked up once
+}
+
+// NewClient returns a client for baseURL, e.g. https://server.luxpowertek.com/WManage
+func NewClient(baseURL, account, password, station string) *Client {
+	jar, _ := cookiejar.New(nil) // Never fails with nil options
+	return &Client{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		account:  account,
+		password: password,
+		station:  station,
+		http:     &http.Client{Jar: jar},
+	}
+}
+
+// Live returns the current inverter readings
+func (c *Client) Live(ctx context.Context) (Live, error) {
+	var runtime runtimeResponse
+	if err := c.withSession(ctx, func() error { return c.runtime(ctx, &runtime) }); err != nil {
+		return Live{}, err
+	}
+	return Live{
+		GridToLoad: runtime.PToUser,
+		SOC:        runtime.SOC,
+		Ppv:        runtime.Ppv,
+		Pload:      runtime.ConsumptionPower,
+	}, nil
+}
+
+// RawLive returns the inverter runtime response as the API sent it, to see what changed when
+// Live stops making sense of it
+func (c *Client) RawLive(ctx context.Context) (json.RawMessage, error) {
+	var raw json.RawMessage
+	err := c.withSession(ctx, func() error { return c.runtime(ctx, &raw) })
+	return raw, err
+}
+
+// withSession runs request logged in, logging in again and retrying once if the session has expired
+func (c *Client) withSession(ctx context.Context, request func() error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.ensureAuth(ctx); err != nil {
+		return err
+	}
+
+	err := request()
+	if errors.Is(err, ErrUnauthorized) {
+		// The server dropped the session before we expected, log in again and retry once
+		c.sessionExpires = time.Time{}
+		if err := c.ensureAuth(ctx); err != nil {
+			return err
+		}
+		err = request()
+	}
+	if err == nil {
+		c.sessionExpires = time.Now().Add(sessionTTL)
+	}
+	return err
+}
+
+// ensureAuth logs in unless the current session is still valid. Must be called with c.mu held.
+func (c *Client) ensureAuth(ctx context.Context) error {
+	if time.Now().Before(c.sessionExpires) {
+		return nil
+	}
+	c.sessionExpires = time.Time{}
+	return c.login(ctx)
+}
+
+func (c *Client) login(ctx context.Context) error {
+	c.http.Jar, _ = cookiejar.New(nil) // Drop the stale session cookie
+	resp, err := c.post(ctx, "/web/login", url.Values{
+		"account":  {c.account},
+		"password": {c.password},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	u, _ := url.Parse(c.baseURL)
+	for _, cookie := range c.http.Jar.Cookies(u) {
+		if cookie.Name == "JSESSIONID" {
+			c.sessionExpires = time.Now().Add(sessionTTL)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: login failed, check account and password", ErrUnauthorized)
+}
+
+// runtimeResponse holds the fields Live uses from the inverter runtime
+type runtimeResponse struct {
+	PToUser          int  `json:"pToUser"`
+	SOC              *int `json:"soc"`
+	Ppv              *int `json:"ppv"`
+	ConsumptionPower *int `json:"consumptionPower"`
+}
+
+// runtime decodes the runtime of the station's inverter into v. Must be called with c.mu held.
+func (c *Client) runtime(ctx context.Context, v any) error {
+	if c.serial == "" {
+		var list struct {
+			Rows []struct {
+				SerialNum string `json:"serialNum"`
+			} `json:"rows"`
+		}
+		if err := c.call(ctx, "/api/inverterOverview/list", url.Values{"plantId": {c.station}, "page": {"1"}}, &list); err != nil {
+			return err
+		}
+		if len(list.Rows) == 0 {
+			return fmt.Errorf("luxpower: no inverters in station %s", c.station)
+		}
+		c.serial = list.Rows[0].SerialNum
+	}
+	return c.call(ctx, "/api/inverter/getInverterRuntime", url.Values{"serialNum": {c.serial}}, v)
+}
+
+// call posts a form to an API endpoint and decodes the JSON response into v
+func (c *Client) call(ctx context.Context, path string, form url.Values, v any) error {
+	resp, err := c.post(ctx, path, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// An expired session is answered with the HTML login page instead of JSON
+	if resp.StatusCode == http.StatusUnauthorized || !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		return ErrUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("luxpower: %s: %s", path, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// Errors come back as 200 with {"success": false, "msg": "..."}
+	var status struct {
+		Success *bool  `json:"success"`
+		Msg     string `json:"msg"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return fmt.Errorf("luxpower: %s: %w", path, err)
+	}
+	if status.Success != nil && !*status.Success {
+		return fmt.Errorf("luxpower: %s: %s", path, status.Msg)
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (c *Client) post(ctx context.Context, path string, form url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.http.Do(req)
+}
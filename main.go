@@ -1,205 +1,1318 @@
 package main
 
 import (
-	"encoding/json"
-	"log"
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
+	_ "time/tzdata" // The Alpine image has no zoneinfo, embed it so TZ works
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
-	checkInterval = 1 * time.Minute // Check every minute. BTW, the inverter pushes data to the LP cloud every 2 minutes
-	recheckDelay  = 1 * time.Minute // Delay before rechecking after state change
+	defaultCheckInterval = 1 * time.Minute // Check every minute. BTW, the inverter pushes data to the LP cloud every 2 minutes
+	defaultRecheckDelay  = 1 * time.Minute // Delay before rechecking after state change
+	retryBackoff         = 2 * time.Second // First delay between go-luxpower retries, doubled on every attempt
+	batteryLowHysteresis = 5               // Points above BATTERY_LOW_THRESHOLD the charge must recover before alerting again
 )
 
 var (
-	telegramBotToken = getenv("TELEGRAM_BOT_TOKEN", "")
-	luxpowerAccount  = getenv("LUXPOWER_ACCOUNT", "")
-	luxpowerPassword = getenv("LUXPOWER_PASSWORD", "")
-	luxpowerStation  = getenv("LUXPOWER_STATION", "")
-	luxpowerBaseURL  = getenv("LUXPOWER_BASEURL", "")
+	telegramBotTokens = parseTokens(cmp.Or(getenv("TELEGRAM_BOT_TOKENS", ""), getenv("TELEGRAM_BOT_TOKEN", ""))) // Several spread the chats over as many bots
+	telegramDebug     = getenvBool("TELEGRAM_DEBUG", false)
+	telegramProxy     = getenv("TELEGRAM_PROXY", "")                    // http://, https:// or socks5:// proxy for reaching Telegram
+	webhookURL        = getenv("WEBHOOK_URL", "")                       // Public HTTPS URL for updates, empty uses long polling
+	webhookListen     = cmp.Or(getenv("WEBHOOK_LISTEN", ""), ":8443")   // Where the webhook server listens, behind a TLS terminating proxy
+	webhookSecret     = getenv("WEBHOOK_SECRET", "")                    // Checked on every webhook request, random if empty
+	broadcastChannels = parseChannels(getenv("BROADCAST_CHANNELS", "")) // Channels always notified, by ID or @username
+	adminIDs          = parseIDs(getenv("ADMIN_IDS", ""))               // Telegram user IDs allowed to run adminCommands
+	luxpowerAccount   = getenv("LUXPOWER_ACCOUNT", "")
+	luxpowerPassword  = getenv("LUXPOWER_PASSWORD", "")
+	luxpowerStation   = getenv("LUXPOWER_STATION", "")
+	luxpowerStations  = getenv("LUXPOWER_STATIONS", "") // Comma-separated, optionally named: "Дім=123,Офіс=456". Overrides LUXPOWER_STATION
+	luxpowerBaseURL   = getenv("LUXPOWER_BASEURL", "")
+	luxpowerClient    = cmp.Or(getenv("LUXPOWER_CLIENT", ""), "http")          // "http" talks to the API directly, "binary" runs go-luxpower
+	luxpowerBinary    = cmp.Or(getenv("LUXPOWER_BINARY", ""), "./go-luxpower") // A path, or a name looked up in $PATH
+	luxpowerTimeout   = getenvDuration("LUXPOWER_TIMEOUT", 30*time.Second)
+	luxpowerRetries   = max(getenvInt("LUXPOWER_MAX_RETRIES", 3), 1)
+	pollJitter        = getenvDuration("POLL_JITTER", 0)              // Random delay of up to this before each poll, so instances don't poll in step
+	stateFile         = getenv("STATE_FILE", "state.json")            // Empty disables persistence
+	metricsAddr       = getenv("METRICS_ADDR", "")                    // Empty disables the Prometheus endpoint
+	healthAddr        = getenv("HEALTH_ADDR", "")                     // Empty disables the /healthz endpoint
+	apiAddr           = getenv("API_ADDR", "")                        // Empty disables the /api/state endpoint
+	apiToken          = getenv("API_TOKEN", "")                       // Bearer token required by /api/state
+	dedupWindow       = getenvDuration("DEDUP_WINDOW", 2*time.Minute) // An identical notification to a chat within this is dropped
+	sendRate          = max(getenvInt("SEND_RATE", 25), 1)            // Telegram messages per second, Telegram allows about 30
+	sendConcurrency   = max(getenvInt("SEND_CONCURRENCY", 4), 1)      // Chats a notification is sent to at once
+	historySize       = max(getenvInt("HISTORY_SIZE", 10), 1)         // Outages listed by /history
+	statsRetention    = time.Duration(max(getenvInt("STATS_RETENTION_DAYS", 7), 1)) * 24 * time.Hour
+	dbPath            = getenv("DB_PATH", "") // SQLite database for every reading, empty keeps readings in memory only
+	dbRetention       = time.Duration(max(getenvInt("DB_RETENTION_DAYS", 90), 1)) * 24 * time.Hour
+	auditLogPath      = getenv("AUDIT_LOG", "") // JSON lines file recording every notification, empty disables it
+
+	gridDownThreshold   = getenvInt("GRID_DOWN_THRESHOLD", 0)                                           // GridToLoad at or below this means no grid
+	notifyOnStart       = getenvBool("NOTIFY_ON_START", false)                                          // Announce restarts to all chats
+	commandCooldown     = getenvDuration("COMMAND_COOLDOWN", 10*time.Second)                            // How often a chat may run cooldownCommands
+	autoRegister        = getenvBool("AUTO_REGISTER", true)                                             // Subscribe every chat the bot sees a message in, otherwise only on /register
+	confirmCount        = max(getenvInt("CONFIRM_COUNT", getenvInt("DOWN_CONFIRM_COUNT", 2)), 1)        // Consecutive readings before an outage or a restore is announced
+	recheckRetries      = max(getenvInt("RECHECK_RETRIES", 3), 0)                                       // Failed rechecks tried again before waiting for the next poll
+	flapCount           = getenvInt("FLAP_COUNT", 0)                                                    // More changes than this within FLAP_WINDOW mean the grid is unstable, 0 disables
+	flapWindow          = getenvDuration("FLAP_WINDOW", 30*time.Minute)                                 // See FLAP_COUNT
+	minOutageDuration   = getenvDuration("MIN_OUTAGE_DURATION", 2*time.Minute)                          // Shorter outages are left out of the statistics
+	batteryLowThreshold = getenvInt("BATTERY_LOW_THRESHOLD", 20)                                        // Alert when the battery drops below this % during an outage, 0 disables
+	batteryCapacityWh   = getenvInt("BATTERY_CAPACITY_WH", 0)                                           // Battery pack size for the runtime estimate, 0 disables it
+	pollFailureAlert    = getenvInt("POLL_FAILURE_ALERT", 5)                                            // Failed polls in a row before the admins are alerted, 0 disables
+	staleDataThreshold  = getenvDuration("STALE_DATA_THRESHOLD", 10*time.Minute)                        // Unchanged readings for this long mean a dead data feed, 0 disables
+	gridImportAlert     = getenvInt("GRID_IMPORT_ALERT", 0)                                             // Alert when GridToLoad rises above this many W, 0 disables
+	location            = loadLocation(cmp.Or(getenv("TIMEZONE", ""), getenv("TZ", ""), "Europe/Kyiv")) // For day boundaries, quiet hours and times in messages
+	quiet               = parseQuietHours(getenv("QUIET_HOURS_START", ""), getenv("QUIET_HOURS_END", ""))
+	sunCoordinates      = parseCoordinates(getenv("LATITUDE", ""), getenv("LONGITUDE", ""))  // For sunrise times in /forecast
+	dailySummaryAt      = parseClockTime("DAILY_SUMMARY_AT", getenv("DAILY_SUMMARY_AT", "")) // Local time to send yesterday's recap, empty disables
+	dailySummaryAlways  = getenvBool("DAILY_SUMMARY_ALWAYS", false)                          // Send the recap even after a day without outages
+
+	mqttBroker   = getenv("MQTT_BROKER", "") // E.g. tcp://homeassistant:1883, empty disables MQTT
+	mqttTopic    = cmp.Or(getenv("MQTT_TOPIC", ""), "luxpower/grid")
+	mqttUsername = getenv("MQTT_USERNAME", "")
+	mqttPassword = getenv("MQTT_PASSWORD", "")
+
+	influxURL    = getenv("INFLUX_URL", "") // E.g. http://influxdb:8086, empty disables the export
+	influxToken  = getenv("INFLUX_TOKEN", "")
+	influxOrg    = getenv("INFLUX_ORG", "")
+	influxBucket = cmp.Or(getenv("INFLUX_BUCKET", ""), "luxpower")
+
+	// Fallback channels for when Telegram is unreachable
+	pushoverToken = getenv("PUSHOVER_TOKEN", "") // Pushover application token
+	pushoverUser  = getenv("PUSHOVER_USER", "")  // Pushover user or group key
+	smtpAddr      = getenv("SMTP_ADDR", "")      // Mail server as host:port, e.g. smtp.gmail.com:587
+	smtpUsername  = getenv("SMTP_USERNAME", "")
+	smtpPassword  = getenv("SMTP_PASSWORD", "")
+	smtpFrom      = getenv("SMTP_FROM", "") // SMTP_USERNAME if empty
+	smtpTo        = getenv("SMTP_TO", "")   // Comma-separated recipients
 )
 
-type LuxpowerResponse struct {
-	GridToLoad int `json:"GridToLoad"`
+// startupGrace is STARTUP_GRACE, read in main as it defaults to RECHECK_DELAY
+var startupGrace time.Duration
+
+// adminCommands change the bot's behaviour for the whole chat, so only admins may run them
+var adminCommands = map[string]bool{
+	"lang":        true,
+	"mute":        true,
+	"unmute":      true,
+	"subscribe":   true,
+	"unsubscribe": true,
+	"register":    true,
+	"test":        true,
+	"notify":      true,
+	"subscribers": true,
+	"raw":         true,
+	"diag":        true,
+	"setstation":  true,
 }
 
-type Bot struct {
-	bot              *tgbotapi.BotAPI
-	currentGridState int
+// cooldownCommands poll the LP cloud, so a chat may run them only once per COMMAND_COOLDOWN
+var cooldownCommands = map[string]bool{
+	"battery": true,
+}
+
+// station is a monitored LuxPower station with its own grid state machine
+type station struct {
+	id     string
+	name   string          // Shown in messages when several stations are monitored
+	source GridStateSource // Where the readings come from
+
+	currentGridState  int
 	previousGridState int
-	mu               sync.Mutex
-	chatIDs          map[int64]bool // Map for Chat IDs
-	recheckScheduled bool           // Flag to avoid multiple rechecks
+	gridPower         int       // Latest GridToLoad reading in W, -1 before the first poll
+	soc               *int      // Latest battery charge in %, nil if unknown
+	ppv               *int      // Latest PV production in W, nil if unknown
+	pload             *int      // Latest consumption in W, nil if unknown
+	recentLoad        []int     // Last loadSamples consumption readings, for /forecast
+	samples           []sample  // Readings of the last chartPeriod, oldest first, for /chart
+	importAlerted     bool      // GRID_IMPORT_ALERT fired and the import hasn't dropped back below it yet
+	batteryAlerted    bool      // BATTERY_LOW_THRESHOLD fired and the charge hasn't recovered yet
+	pollFailures      int       // Consecutive failed polls
+	pollAlerted       bool      // Admins were told about the failures and not yet about the recovery
+	recheckPending    bool      // Guard so only one recheck is ever outstanding
+	recheckTimer      Timer     // The pending recheck, to cancel it when it's no longer needed
+	recheckFailures   int       // Failed rechecks of the current change, retried up to RECHECK_RETRIES
+	changeCount       int       // Consecutive readings disagreeing with the announced state
+	changeSince       time.Time // Time of the first of those readings
+	outageStart       time.Time // Start of the announced outage, zero if unknown
+	stateSince        time.Time // Last announced transition, zero if none was seen since the bot started
+
+	lastReading  LuxpowerResponse // Previous reading, to spot a feed stuck on the same values
+	readingSince time.Time        // When the readings last changed
+	staleAlerted bool             // Admins were told the data is stale and not yet that it updates again
+
+	transitions []time.Time // Confirmed changes within FLAP_WINDOW, oldest first
+	flapping    bool        // The grid was announced as unstable and its changes are held back
+
+	graceFrom time.Time // First reading when the bot started without a saved state, for STARTUP_GRACE
+
+	override   string // Station ID polled instead of id, set with /setstation, empty for the configured one
+	generation int    // Bumped by /setstation, so a reading of the previous station still in flight is dropped
 }
 
-func NewBot(token string) (*Bot, error) {
-	bot, err := tgbotapi.NewBotAPI(token)
+type Bot struct {
+	shards   []*shard   // The bots of TELEGRAM_BOT_TOKENS, chats are spread over them
+	chatBots chatBots   // The bot serving each chat, see claimChat
+	stations []*station // In configured order
+	mu       sync.Mutex
+	chats    map[int64]ChatInfo  // Every chat the bot has seen, subscribed or not
+	cooldown map[int64]time.Time // When each chat last ran one of the cooldownCommands
+	outages  []outage            // Outage history for /stats, oldest first
+
+	quietQueue       []quietEvent                // Notifications held back during quiet hours, oldest first
+	pending          []pendingNotification       // Notifications Telegram hasn't confirmed yet, oldest first
+	batching         int                         // Running polls and rechecks, while any is notify collects into batch
+	batch            []quietEvent                // Notifications of the running poll, for flushBatch
+	lastNotification map[string]sentNotification // By chat ID or channel, to drop duplicates
+
+	delivering int            // Sends running with b.mu released, see unlocked
+	sending    sync.WaitGroup // The same sends and those of sendAsync, for Start to wait for before the final save
+	closing    bool           // Start is shutting down, no new sends are started
+
+	lastPollTime time.Time // Last successful poll, for /healthz
+	lastPollErr  error     // Error of the last poll, nil if it succeeded
+
+	startedAt       time.Time // For /uptime
+	monitoringSince time.Time // First start with this state file, for /reliability
+
+	checkInterval time.Duration // CHECK_INTERVAL, set by Start for /diag
+	recheckDelay  time.Duration // RECHECK_DELAY, set by Start for /diag
+
+	mqtt   *mqttPublisher // Nil without MQTT_BROKER
+	db     *readingsDB    // Nil without DB_PATH
+	influx *influxWriter  // Nil without INFLUX_URL
+	audit  *auditLog      // Nil without AUDIT_LOG
+
+	manualPolls singleflight.Group // Polls for /api/poll by station ID, so concurrent requests share one
+}
+
+func NewBot(tokens []string) (*Bot, error) {
+	var shards []*shard
+	for _, token := range tokens {
+		bot, err := tgbotapi.NewBotAPIWithClient(token, tgbotapi.APIEndpoint, telegramHTTPClient())
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, newShard(bot))
+	}
+
+	st, err := loadState(stateFile)
 	if err != nil {
 		return nil, err
 	}
+
+	var db *readingsDB
+	if dbPath != "" {
+		if db, err = openReadingsDB(dbPath); err != nil {
+			return nil, fmt.Errorf("opening DB_PATH: %w", err)
+		}
+	}
+
+	var audit *auditLog
+	if auditLogPath != "" {
+		if audit, err = openAuditLog(auditLogPath); err != nil {
+			return nil, fmt.Errorf("opening AUDIT_LOG: %w", err)
+		}
+	}
+
+	stations := parseStations(cmp.Or(luxpowerStations, luxpowerStation))
+	for _, s := range stations {
+		s.restore(st.Stations)
+		if override := st.Overrides[s.id]; override != "" {
+			s.override, s.source = override, newGridStateSource(override)
+		}
+		if db != nil {
+			db.restore(s, clock.Now())
+		}
+	}
+
+	// An outage left open by the previous run can only be closed if its station is still known to be down
+	outages := slices.DeleteFunc(pruneOutages(st.Outages, clock.Now()), func(o outage) bool {
+		if !o.End.IsZero() {
+			return false
+		}
+		i := slices.IndexFunc(stations, func(s *station) bool { return s.id == o.Station })
+		return i < 0 || !isGridDown(stations[i].previousGridState)
+	})
+
+	// State files from before it was saved start monitoring at the oldest retained outage
+	monitoringSince := st.Since
+	if monitoringSince.IsZero() {
+		monitoringSince = clock.Now()
+		if len(outages) > 0 {
+			monitoringSince = outages[0].Start
+		}
+	}
+
 	return &Bot{
-		bot:               bot,
-		currentGridState:  -1, // Initialize with a value that cannot be the power supply state
-		previousGridState: -1,
-		chatIDs:           make(map[int64]bool),
-		recheckScheduled:  false,
+		shards:           shards,
+		chatBots:         chatBots{byChat: st.ChatBots},
+		stations:         stations,
+		chats:            st.Chats,
+		pending:          st.Pending,
+		cooldown:         make(map[int64]time.Time),
+		outages:          outages,
+		startedAt:        clock.Now(),
+		monitoringSince:  monitoringSince,
+		lastNotification: make(map[string]sentNotification),
+		mqtt:             newMQTTPublisher(),
+		db:               db,
+		influx:           newInfluxWriter(),
+		audit:            audit,
 	}, nil
 }
 
-func (b *Bot) Start() {
-	b.bot.Debug = true // Bot debug
+// telegramHTTPClient returns the client for the Telegram API, going through TELEGRAM_PROXY if set.
+// The URL was checked by validateConfig.
+func telegramHTTPClient() *http.Client {
+	if telegramProxy == "" {
+		return &http.Client{Timeout: telegramTimeout}
+	}
+	proxy, _ := url.Parse(telegramProxy)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxy)
+	return &http.Client{Transport: transport, Timeout: telegramTimeout}
+}
+
+// parseStations parses LUXPOWER_STATIONS. An entry is either a station ID or "name=ID".
+func parseStations(spec string) []*station {
+	var stations []*station
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, id, named := strings.Cut(entry, "=")
+		if !named {
+			id = name
+		}
+		id = strings.TrimSpace(id)
+		stations = append(stations, newStation(id, strings.TrimSpace(name), newGridStateSource(id)))
+	}
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	// Keep the old behaviour of polling go-luxpower without a station
+	if len(stations) == 0 {
+		stations = append(stations, newStation("", "", newGridStateSource("")))
+	}
+	return stations
+}
 
-	updates := b.bot.GetUpdatesChan(u)
+// restore picks up the grid state announced before a restart, if it was saved
+func (st *station) restore(saved map[string]stationState) {
+	state, ok := saved[st.id]
+	if !ok {
+		return
+	}
+	st.currentGridState = state.GridState
+	st.previousGridState = state.GridState
+	st.outageStart = state.OutageStart
+	st.stateSince = state.Since
+}
+
+func newStation(id, name string, source GridStateSource) *station {
+	return &station{
+		id:                id,
+		name:              name,
+		source:            source,
+		currentGridState:  -1, // Initialize with a value that cannot be the power supply state
+		previousGridState: -1,
+		gridPower:         -1,
+	}
+}
+
+// Start handles Telegram updates and polls the inverter until ctx is cancelled, then saves the state and returns
+func (b *Bot) Start(ctx context.Context, checkInterval, recheckDelay, restoreRecheckDelay time.Duration) error {
+	for _, sh := range b.shards {
+		sh.api.Debug = telegramDebug // Logs every raw Telegram API call
+	}
+	b.checkBroadcastChannels()
+	b.checkInterval, b.recheckDelay = checkInterval, recheckDelay
+
+	updates, stopUpdates, err := b.receiveUpdates()
+	if err != nil {
+		return err
+	}
 
 	// Separate goroutine for processing updates
 	go b.handleUpdates(updates)
 
-	// Cycle to periodically check the status of the power supply system
-	ticker := time.NewTicker(checkInterval)
+	// Notifications the previous run didn't get out, e.g. because it crashed while sending them
+	b.mu.Lock()
+	b.retryPending()
+	b.mu.Unlock()
+
+	if dailySummaryAt != nil {
+		go b.runDailySummary(ctx)
+	}
+	if b.db != nil {
+		go b.db.runPruning(ctx)
+	}
+	if b.influx != nil {
+		go b.influx.run(ctx)
+	}
+
+	b.poll(ctx, checkInterval, recheckDelay, restoreRecheckDelay)
+
+	slog.Info("Shutting down")
+	stopUpdates()
+
+	// Waits for any in-flight notification, so it isn't sent again on the next start. New ones stay
+	// pending for it, as sending.Add mustn't race with Wait.
+	b.mu.Lock()
+	b.closing = true
+	b.mu.Unlock()
+	b.sending.Wait()
+	b.mu.Lock()
+	b.saveState()
+	b.mu.Unlock()
+	b.mqtt.close()
+	b.db.close()
+	b.influx.flush()
+	b.audit.close()
+	return nil
+}
+
+// poll periodically checks the status of the power supply system until ctx is cancelled
+func (b *Bot) poll(ctx context.Context, checkInterval, recheckDelay, restoreRecheckDelay time.Duration) {
+	ticker := clock.NewTicker(checkInterval)
 	defer ticker.Stop()
 
-	for {
-		<-ticker.C
+	startAnnounced := !notifyOnStart // Announce after the first successful poll, so the state is known
 
-		gridState, err := b.getCurrentGridState()
-		if err != nil {
-			log.Println("Error getting current grid state:", err)
-			continue
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
 		}
 
-		b.mu.Lock()
-		if gridState == 0 && b.previousGridState != 0 {
-			log.Printf("Grid state changed: %d -> %d\n", b.previousGridState, gridState)
-
-			// Set current state
-			b.currentGridState = gridState
-
-			// Schedule recheck after recheckDelay if not already scheduled
-			if !b.recheckScheduled {
-				b.recheckScheduled = true
-				time.AfterFunc(recheckDelay, func() {
-					b.mu.Lock()
-					defer b.mu.Unlock()
-
-					// Recheck current state
-					currentState, err := b.getCurrentGridState()
-					if err != nil {
-						log.Println("Error re-checking current grid state:", err)
-						return
-					}
-
-					if currentState == 0 {
-						log.Println("Grid state is still 0 after recheck, sending notification.")
-						b.sendToAllGroups("Стан змінився: світла немає.")
-						b.previousGridState = currentState
-					} else {
-						log.Println("Grid state changed during recheck: 0 ->", currentState)
-						b.currentGridState = currentState
-						b.previousGridState = currentState
-					}
-
-					b.recheckScheduled = false // Reset recheck flag
-				})
+		if pollJitter > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(rand.N(pollJitter)):
 			}
-		} else if gridState != 0 && b.previousGridState == 0 {
-			log.Printf("Grid state changed: %d -> %d\n", b.previousGridState, gridState)
-			b.currentGridState = gridState
-			b.sendToAllGroups("Стан змінився: світло є.")
-			b.previousGridState = gridState
 		}
-		b.mu.Unlock()
+
+		polled := b.pollStations(ctx, recheckDelay, restoreRecheckDelay)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if polled && !startAnnounced {
+			startAnnounced = true
+			b.mu.Lock()
+			b.sendToAllGroups(categoryGrid, func(lang string) string {
+				return tr(lang, "restarted") + "\n" + b.statusText(lang)
+			})
+			b.mu.Unlock()
+		}
+	}
+}
+
+// pollStations polls every station once. What the readings change is sent as one batch afterwards,
+// along with the notifications due after quiet hours and the undelivered ones. It reports whether any
+// poll succeeded.
+func (b *Bot) pollStations(ctx context.Context, recheckDelay, restoreRecheckDelay time.Duration) bool {
+	b.mu.Lock()
+	b.startBatch()
+	b.mu.Unlock()
+
+	polled := false
+	for _, st := range b.stations {
+		if b.pollStation(ctx, st, recheckDelay, restoreRecheckDelay) {
+			polled = true
+		}
+		if ctx.Err() != nil {
+			return false // Shutting down, nothing more is sent
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushBatch()
+	b.flushQuietQueue()
+	b.retryPending()
+	return polled
+}
+
+// pollStation polls st and feeds the reading into the state machine. It reports whether the poll succeeded.
+func (b *Bot) pollStation(ctx context.Context, st *station, recheckDelay, restoreRecheckDelay time.Duration) bool {
+	generation := b.stationGeneration(st)
+	response, err := b.getLiveData(ctx, st)
+	if ctx.Err() != nil {
+		return false // Cancelled mid-poll, not a poll failure
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if st.generation != generation {
+		slog.Info("Dropping reading of the station polled before /setstation", "station", st.id)
+		return false
+	}
+	b.recordPoll(err)
+	b.checkPollFailures(st, err)
+	if err != nil {
+		slog.Error("Error getting current grid state", "station", st.id, "err", err)
+		incPollErrorsMetric(st.id)
+		return false
+	}
+	b.processGridState(ctx, st, response, recheckDelay, restoreRecheckDelay)
+	return true
+}
+
+// processGridState feeds a reading into the on/off state machine. An outage is rechecked after recheckDelay,
+// a restore after restoreRecheckDelay. Must be called with b.mu held.
+func (b *Bot) processGridState(ctx context.Context, st *station, response LuxpowerResponse, recheckDelay, restoreRecheckDelay time.Duration) {
+	gridState := response.GridToLoad
+	if st.gridPower < 0 && st.previousGridState < 0 {
+		st.graceFrom = clock.Now()
+	}
+	setGridStateMetric(st.id, !isGridDown(gridState))
+	st.gridPower = gridState
+	st.soc = response.SOC
+	st.ppv = response.Ppv
+	st.pload = response.Pload
+	st.recordLoad()
+	st.recordSample(clock.Now(), response)
+	b.db.insert(st.polledID(), clock.Now(), response)
+	b.influx.add(st, clock.Now(), response)
+	b.checkGridImport(st)
+	b.checkBatteryLow(st)
+	b.checkStaleData(st, response)
+	b.checkStable(st, clock.Now())
+
+	// Without a saved state the first up reading is taken as is, so it's remembered across restarts
+	if st.previousGridState < 0 && !isGridDown(gridState) {
+		st.currentGridState = gridState
+		st.previousGridState = gridState
+	}
+
+	// A change is only announced after confirmCount readings in a row agree on it, in either direction
+	if isGridDown(gridState) == isGridDown(st.previousGridState) {
+		if st.changeCount > 0 {
+			slog.Info("Grid state changed back before the change was confirmed", "station", st.id, "from", st.currentGridState, "to", gridState)
+			st.changeCount = 0
+			st.cancelRecheck() // Otherwise it would fire early into the next run of changed readings
+			st.currentGridState = gridState
+			st.previousGridState = gridState
+		}
+		return
+	}
+
+	st.changeCount++
+	if st.changeCount == 1 {
+		st.changeSince = clock.Now()
+		st.recheckFailures = 0
+	}
+	slog.Info("Grid state changed", "station", st.id, "from", st.previousGridState, "to", gridState, "reading", st.changeCount, "confirm_count", confirmCount)
+
+	// Set current state
+	st.currentGridState = gridState
+
+	if st.changeCount < confirmCount {
+		b.scheduleRecheck(ctx, st, recheckDelay, restoreRecheckDelay)
+		return
+	}
+
+	since, soc := st.changeSince, st.soc // Each reading has its own SOC pointer, so this keeps the charge at confirmation
+	st.changeCount = 0
+	st.cancelRecheck()
+
+	// Right after a start without a saved state the previous state is unknown, so a change that began
+	// within STARTUP_GRACE is only the state the grid was already in and becomes the baseline
+	if !st.graceFrom.IsZero() && since.Sub(st.graceFrom) < startupGrace {
+		slog.Info("Grid state taken as the baseline without announcing it", "station", st.id, "down", isGridDown(gridState))
+		st.previousGridState = gridState
+		b.publishState(st, isGridDown(gridState), since)
+		return
+	}
+
+	announce := b.recordTransition(st, since)
+	if isGridDown(gridState) {
+		slog.Info("Grid down confirmed", "station", st.id, "announce", announce)
+		if announce {
+			b.notify(categoryGrid, func(lang string) string {
+				return b.gridDownText(lang, st, soc)
+			})
+		}
+		b.publishState(st, true, since)
+		st.previousGridState = gridState
+		st.outageStart = since
+		st.stateSince = since
+		b.startOutage(st, since)
+		return
+	}
+
+	slog.Info("Grid up confirmed", "station", st.id, "announce", announce)
+	outageDuration := time.Duration(0)
+	// Without a start time (e.g. the bot restarted mid-outage) the duration is unknown
+	if !st.outageStart.IsZero() {
+		outageDuration = since.Sub(st.outageStart)
+	}
+	if announce {
+		b.notify(categoryGrid, func(lang string) string {
+			return b.gridUpText(lang, st, outageDuration, soc)
+		})
+	}
+	b.publishState(st, false, since)
+	st.previousGridState = gridState
+	st.outageStart = time.Time{}
+	st.stateSince = since
+	b.endOutage(st, since)
+}
+
+// checkGridImport alerts once when the grid import rises above GRID_IMPORT_ALERT.
+// Must be called with b.mu held.
+func (b *Bot) checkGridImport(st *station) {
+	if gridImportAlert <= 0 {
+		return
+	}
+
+	power := st.gridPower
+	if power <= gridImportAlert {
+		st.importAlerted = false
+		return
+	}
+	if st.importAlerted {
+		return
 	}
+
+	st.importAlerted = true
+	slog.Info("Grid import above alert threshold", "station", st.id, "power", power, "threshold", gridImportAlert)
+	b.notify(categoryImport, func(lang string) string {
+		return b.stationText(st, tr(lang, "grid_import_alert", gridImportAlert, power))
+	})
+}
+
+// checkBatteryLow alerts once when the battery drops below BATTERY_LOW_THRESHOLD while the grid is down.
+// The alert rearms when the charge recovers batteryLowHysteresis points above the threshold. Must be called with b.mu held.
+func (b *Bot) checkBatteryLow(st *station) {
+	if batteryLowThreshold <= 0 || st.soc == nil {
+		return
+	}
+
+	soc := *st.soc
+	if soc > batteryLowThreshold+batteryLowHysteresis {
+		st.batteryAlerted = false
+		return
+	}
+	if st.batteryAlerted || soc >= batteryLowThreshold || !isGridDown(st.gridPower) {
+		return
+	}
+
+	st.batteryAlerted = true
+	slog.Info("Battery low during outage", "station", st.id, "soc", soc, "threshold", batteryLowThreshold)
+	b.notify(categoryBattery, func(lang string) string {
+		return withEmoji(emojiBattery, b.stationText(st, tr(lang, "battery_low", soc)))
+	})
 }
 
-func (b *Bot) handleUpdates(updates tgbotapi.UpdatesChannel) {
+// scheduleRecheck schedules a recheck of the changed reading of st, if not already scheduled. The grid
+// often flickers when it comes back, so a restore may be given longer to settle. Must be called with b.mu held.
+func (b *Bot) scheduleRecheck(ctx context.Context, st *station, recheckDelay, restoreRecheckDelay time.Duration) {
+	if st.recheckPending {
+		return
+	}
+	delay := recheckDelay
+	if !isGridDown(st.currentGridState) {
+		delay = restoreRecheckDelay
+	}
+	st.recheckPending = true
+	st.recheckTimer = clock.AfterFunc(delay, func() {
+		b.recheck(ctx, st, recheckDelay, restoreRecheckDelay)
+	})
+}
+
+// recheck polls again after a changed reading and feeds the result into the state machine.
+// A failed recheck is tried again up to RECHECK_RETRIES times, so the change isn't left waiting for the next poll.
+func (b *Bot) recheck(ctx context.Context, st *station, recheckDelay, restoreRecheckDelay time.Duration) {
+	if ctx.Err() != nil {
+		return // Shutting down
+	}
+	generation := b.stationGeneration(st)
+	response, err := b.getLiveData(ctx, st)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Like a poll, the notifications go out once the state is complete, as sending releases b.mu
+	b.startBatch()
+	defer b.flushBatch()
+
+	st.recheckPending = false // Reset recheck flag
+	if st.generation != generation {
+		slog.Info("Dropping recheck of the station polled before /setstation", "station", st.id)
+		return
+	}
+	if err != nil {
+		slog.Error("Error re-checking current grid state", "station", st.id, "err", err)
+		incPollErrorsMetric(st.id)
+		if st.changeCount > 0 && st.recheckFailures < recheckRetries {
+			st.recheckFailures++
+			slog.Info("Retrying recheck", "station", st.id, "retry", st.recheckFailures, "max_retries", recheckRetries)
+			b.scheduleRecheck(ctx, st, recheckDelay, restoreRecheckDelay)
+		}
+		return
+	}
+	b.processGridState(ctx, st, response, recheckDelay, restoreRecheckDelay)
+}
+
+// cancelRecheck stops the pending recheck, if any. Must be called with b.mu held.
+func (st *station) cancelRecheck() {
+	// If Stop fails the callback is already running and clears the flag itself
+	if st.recheckPending && st.recheckTimer.Stop() {
+		st.recheckPending = false
+	}
+}
+
+func (b *Bot) handleUpdates(updates <-chan update) {
 	for update := range updates {
-		if update.Message == nil { // Ignore updates that are not messages
-			continue
+		b.handleUpdate(update)
+	}
+}
+
+// handleUpdate processes a single update. A panic is logged and swallowed, so one bad
+// message can't stop the bot from answering commands.
+func (b *Bot) handleUpdate(update update) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Panic handling update", "update_id", update.UpdateID, "panic", r, "update", fmt.Sprintf("%+v", update.Update), "stack", string(debug.Stack()))
 		}
+	}()
 
-		if update.Message.Chat != nil {
-			chatID := update.Message.Chat.ID
-			if !b.chatIDs[chatID] {
-				log.Printf("Bot added to new chat: %d\n", chatID)
-				b.chatIDs[chatID] = true
-			}
+	// With several bots every one of them sees the chats it's in, but only the one serving a chat answers
+	if chat := update.FromChat(); chat != nil && !b.claimChat(chat, update.shard) {
+		slog.Debug("Ignoring update for a chat another bot serves", "update_id", update.UpdateID, "chat_id", chat.ID)
+		return
+	}
+
+	switch {
+	case update.Message != nil:
+		b.handleMessage(update.Message, update.threadID)
+	case update.ChannelPost != nil: // Commands posted in a channel the bot administers
+		b.handleMessage(update.ChannelPost, 0)
+	case update.CallbackQuery != nil:
+		b.handleCallbackQuery(update.CallbackQuery, b.shards[update.shard])
+	default: // Edited messages and the like, re-running a command on edit would be a surprise
+		slog.Debug("Ignoring update", "update_id", update.UpdateID)
+	}
+}
+
+// handleMessage registers the chat and runs the command in the message, if any.
+// threadID is the forum topic the message was posted in, 0 outside topics.
+func (b *Bot) handleMessage(message *tgbotapi.Message, threadID int) {
+	slog.Debug("Message received", "message_id", message.MessageID, "text", message.Text)
+	if message.Chat == nil {
+		return
+	}
+
+	chatID := message.Chat.ID
+	b.mu.Lock()
+	// Chats that unsubscribed stay in the map, so they are not re-added here
+	if _, known := b.chats[chatID]; !known && autoRegister {
+		slog.Info("Bot added to new chat", "chat_id", chatID, "title", chatTitle(message.Chat))
+		b.chats[chatID] = ChatInfo{Subscribed: true}
+		b.saveState()
+	}
+	b.mu.Unlock()
+
+	if message.IsCommand() {
+		b.runCommand(chatID, threadID, message.From, message.Command(), strings.TrimSpace(message.CommandArguments()))
+	}
+
+	// After the command, so a chat added by /register gets its title right away
+	b.mu.Lock()
+	b.rememberChat(message.Chat)
+	b.mu.Unlock()
+}
+
+// runCommand dispatches a command typed in a chat or sent by a button. user is nil for channel posts.
+func (b *Bot) runCommand(chatID int64, threadID int, user *tgbotapi.User, command, args string) {
+	if adminCommands[command] && !isAdmin(user) {
+		b.mu.Lock()
+		lang := b.chatLanguage(chatID)
+		b.mu.Unlock()
+		b.sendMessageToGroup(chatID, tr(lang, "not_allowed"))
+		return
+	}
+
+	if cooldownCommands[command] {
+		if wait := b.startCooldown(chatID); wait > 0 {
+			b.mu.Lock()
+			lang := b.chatLanguage(chatID)
+			b.mu.Unlock()
+			b.sendMessageToGroup(chatID, tr(lang, "cooldown", int(wait.Seconds())+1))
+			return
 		}
+	}
 
-		if update.Message.IsCommand() {
-			switch update.Message.Command() {
-			case "status":
-				b.handleStatusCommand(update.Message.Chat.ID)
-			}
+	switch command {
+	case "status":
+		b.handleStatusCommand(chatID)
+	case "lang":
+		b.handleLangCommand(chatID, args)
+	case "help", "start":
+		lang := ""
+		if user != nil {
+			lang = user.LanguageCode
 		}
+		b.handleHelpCommand(chatID, lang)
+	case "battery":
+		go b.handleBatteryCommand(chatID) // Polls the LP cloud, don't block other commands
+	case "grid":
+		b.handleGridCommand(chatID)
+	case "uptime":
+		b.handleUptimeCommand(chatID)
+	case "forecast":
+		b.handleForecastCommand(chatID)
+	case "ping":
+		b.handlePingCommand(chatID)
+	case "id":
+		b.handleIDCommand(chatID, user)
+	case "version":
+		b.handleVersionCommand(chatID)
+	case "stats":
+		b.handleStatsCommand(chatID)
+	case "reliability":
+		b.handleReliabilityCommand(chatID)
+	case "next_summary":
+		b.handleNextSummaryCommand(chatID)
+	case "history":
+		b.handleHistoryCommand(chatID)
+	case "export":
+		b.handleExportCommand(chatID)
+	case "chart":
+		b.handleChartCommand(chatID)
+	case "mute":
+		b.handleMuteCommand(chatID, args)
+	case "unmute":
+		b.handleUnmuteCommand(chatID)
+	case "subscribe", "register": // Without AUTO_REGISTER /register is how a chat opts in, it's the same thing
+		b.handleSubscribeCommand(chatID, threadID, true)
+	case "unsubscribe":
+		b.handleSubscribeCommand(chatID, threadID, false)
+	case "notify":
+		b.handleNotifyCommand(chatID, args)
+	case "test":
+		b.handleTestCommand(chatID)
+	case "subscribers":
+		b.handleSubscribersCommand(chatID)
+	case "raw":
+		go b.handleRawCommand(chatID) // Polls the LP cloud, like /battery
+	case "diag":
+		b.handleDiagCommand(chatID)
+	case "setstation":
+		go b.handleSetStationCommand(chatID, args) // Test-polls the LP cloud, like /battery
+	default:
+		slog.Debug("Unknown command", "chat_id", chatID, "command", command)
 	}
 }
 
+// startCooldown starts the COMMAND_COOLDOWN of a chat, or returns how much of it is left if it is still running
+func (b *Bot) startCooldown(chatID int64) time.Duration {
+	now := clock.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if wait := b.cooldown[chatID].Add(commandCooldown).Sub(now); wait > 0 {
+		return wait
+	}
+	b.cooldown[chatID] = now
+	return 0
+}
+
 func (b *Bot) handleStatusCommand(chatID int64) {
-	gridStateStr := "Світло є."
-	if b.currentGridState == 0 {
-		gridStateStr = "Світла немає."
+	// Copy the state under the lock so we don't hold it during the Telegram call
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	text := b.statusText(lang)
+	b.mu.Unlock()
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = commandKeyboard(lang)
+	b.sendMessage(msg)
+}
+
+// handleHelpCommand lists the commands in the chat's language or, if none was chosen, the user's
+func (b *Bot) handleHelpCommand(chatID int64, userLang string) {
+	b.mu.Lock()
+	lang := b.chats[chatID].Language
+	b.mu.Unlock()
+	if lang == "" {
+		lang = userLang
+	}
+
+	help, ok := commandHelp[lang]
+	if !ok {
+		help = commandHelp[defaultLanguage]
+	}
+
+	commands := make([]string, 0, len(help))
+	for command := range help {
+		commands = append(commands, command)
 	}
+	sort.Strings(commands)
 
-	msg := tgbotapi.NewMessage(chatID, gridStateStr)
-	if _, err := b.bot.Send(msg); err != nil {
-		log.Println("Error sending message:", err)
+	var sb strings.Builder
+	for _, command := range commands {
+		fmt.Fprintf(&sb, "/%s - %s\n", command, help[command])
 	}
+	msg := tgbotapi.NewMessage(chatID, sb.String())
+	msg.ReplyMarkup = commandKeyboard(lang)
+	b.sendMessage(msg)
 }
 
-func (b *Bot) getCurrentGridState() (int, error) {
-	cmd := exec.Command("./go-luxpower", "live", "--json",
-		"--accountname", luxpowerAccount,
-		"--password", luxpowerPassword,
-		"--station", luxpowerStation,
-		"--baseurl", luxpowerBaseURL)
+// handleGridCommand reports the grid import from the latest poll
+func (b *Bot) handleGridCommand(chatID int64) {
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	lines := make([]string, 0, len(b.stations))
+	for _, st := range b.stations {
+		text := tr(lang, "grid_power_unknown")
+		if st.gridPower >= 0 {
+			text = tr(lang, "grid_power", st.gridPower)
+		}
+		lines = append(lines, b.stationText(st, text))
+	}
+	b.mu.Unlock()
 
-	output, err := cmd.Output()
-	if err != nil {
-		return -1, err // Return -1 to indicate an error
+	b.sendMessageToGroup(chatID, strings.Join(lines, "\n"))
+}
+
+// handlePingCommand answers right away from memory, without polling, so it works while the LP cloud is down
+func (b *Bot) handlePingCommand(chatID int64) {
+	now := clock.Now()
+
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	lastPoll := b.lastPollTime
+	b.mu.Unlock()
+
+	text := tr(lang, "pong", now.In(location).Format("02.01 15:04:05"))
+	if lastPoll.IsZero() {
+		text += "\n" + tr(lang, "pong_no_poll")
+	} else {
+		text += "\n" + tr(lang, "pong_last_poll", formatDuration(lang, now.Sub(lastPoll)))
+	}
+	b.sendMessageToGroup(chatID, text)
+}
+
+// handleIDCommand replies with the chat and user IDs, which Telegram doesn't show but ADMIN_IDS and BROADCAST_CHANNELS need
+func (b *Bot) handleIDCommand(chatID int64, user *tgbotapi.User) {
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	b.mu.Unlock()
+
+	text := tr(lang, "id_chat", chatID)
+	if user != nil { // Channel posts have no sender
+		text += "\n" + tr(lang, "id_user", user.ID)
+	}
+	b.sendMessageToGroup(chatID, text)
+}
+
+// handleUptimeCommand reports how long the bot has been running and how long each station has been in its current state
+func (b *Bot) handleUptimeCommand(chatID int64) {
+	now := clock.Now()
+
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	lines := []string{tr(lang, "uptime_bot", formatDuration(lang, now.Sub(b.startedAt)))}
+	for _, st := range b.stations {
+		state := "up"
+		if isGridDown(st.previousGridState) {
+			state = "down"
+		}
+
+		// Without a transition since the start, the state has lasted at least as long as the bot has been running
+		text := tr(lang, "uptime_"+state+"_unknown", formatDuration(lang, now.Sub(b.startedAt)))
+		if !st.stateSince.IsZero() {
+			text = tr(lang, "uptime_"+state, formatDuration(lang, now.Sub(st.stateSince)))
+		}
+		lines = append(lines, b.stationText(st, text))
 	}
+	b.mu.Unlock()
+
+	b.sendMessageToGroup(chatID, strings.Join(lines, "\n"))
+}
+
+func (b *Bot) handleBatteryCommand(chatID int64) {
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	b.mu.Unlock()
+
+	var messages []string
+	for _, st := range b.stations {
+		response, err := b.getLiveData(context.Background(), st)
+		if err != nil {
+			slog.Error("Error getting live data", "station", st.id, "err", err)
+			messages = append(messages, b.stationText(st, tr(lang, "fetch_failed")))
+			continue
+		}
 
-	var response LuxpowerResponse
-	if err := json.Unmarshal(output, &response); err != nil {
-		return -1, err // Return -1 to indicate an error
+		messages = append(messages, b.stationText(st, tr(lang, "battery",
+			formatReading(lang, response.SOC, "%d%%"),
+			formatReading(lang, response.Ppv, tr(lang, "watts")),
+			formatReading(lang, response.Pload, tr(lang, "watts")))))
 	}
+	b.sendMessageToGroup(chatID, strings.Join(messages, "\n\n"))
+}
 
-	return response.GridToLoad, nil
+// formatDuration renders a duration as "2 год 14 хв"
+func formatDuration(lang string, d time.Duration) string {
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+	if hours == 0 {
+		return tr(lang, "minutes", minutes)
+	}
+	return tr(lang, "hours_minutes", hours, minutes)
 }
 
-func (b *Bot) sendToAllGroups(message string) {
-	for chatID := range b.chatIDs {
-		b.sendMessageToGroup(chatID, message)
+// formatSpan renders a duration that may be days long as "3 дн 5 год"
+func formatSpan(lang string, d time.Duration) string {
+	days := int(d.Hours()) / 24
+	if days == 0 {
+		return formatDuration(lang, d)
 	}
+	return tr(lang, "days_hours", days, int(d.Hours())%24)
 }
 
-func (b *Bot) sendMessageToGroup(chatID int64, message string) {
-	msg := tgbotapi.NewMessage(chatID, message)
-	if _, err := b.bot.Send(msg); err != nil {
-		log.Println("Error sending message:", err)
+// formatReading renders an optional inverter value with format, "н/д" if the inverter didn't report it
+func formatReading(lang string, value *int, format string) string {
+	if value == nil {
+		return tr(lang, "not_available")
 	}
+	return fmt.Sprintf(format, *value)
 }
 
+// handleSubscribeCommand turns notifications on or off. In a forum supergroup they go to the topic
+// /subscribe was sent in.
+func (b *Bot) handleSubscribeCommand(chatID int64, threadID int, subscribe bool) {
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	if subscribe {
+		b.updateChat(chatID, func(c *ChatInfo) {
+			c.Subscribed = true
+			c.Thread = threadID
+		})
+		b.saveState()
+	} else {
+		b.removeChat(chatID)
+	}
+	b.mu.Unlock()
+
+	if subscribe {
+		slog.Info("Chat subscribed", "chat_id", chatID, "thread_id", threadID)
+		b.sendInThread(tgbotapi.NewMessage(chatID, tr(lang, "subscribed")), threadID)
+	} else {
+		slog.Info("Chat unsubscribed", "chat_id", chatID)
+		b.sendInThread(tgbotapi.NewMessage(chatID, tr(lang, "unsubscribed")), threadID)
+	}
+}
+
+// handleTestCommand sends a test message to every chat through the same path as real notifications,
+// so an admin can check everyone is reachable. Muted chats are skipped, chats that turned off
+// categories with /notify are not.
+func (b *Bot) handleTestCommand(chatID int64) {
+	slog.Info("Sending test notification", "chat_id", chatID)
+	at := clock.Now().In(location).Format("15:04:05") // Keeps a repeated test from being dropped as a duplicate
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sendToChats(func(lang string, _ func(category) bool) string {
+		return tr(lang, "test_notification", at)
+	})
+}
+
+// statusText describes the grid state of every station. Must be called with b.mu held.
+func (b *Bot) statusText(lang string) string {
+	lines := make([]string, 0, len(b.stations))
+	for _, st := range b.stations {
+		lines = append(lines, b.stationText(st, gridStateText(lang, st.currentGridState)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// stationName returns the name of the station with the given ID, or the ID if it is no longer monitored.
+// Must be called with b.mu held.
+func (b *Bot) stationName(id string) string {
+	for _, st := range b.stations {
+		if st.id == id {
+			return st.name
+		}
+	}
+	return id
+}
+
+// stationText prefixes text with the station name when several stations are monitored
+func (b *Bot) stationText(st *station, text string) string {
+	if len(b.stations) == 1 {
+		return text
+	}
+	return bold(st.name) + ": " + text
+}
+
+func (b *Bot) handleMuteCommand(chatID int64, arg string) {
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	b.mu.Unlock()
+
+	d, err := time.ParseDuration(arg)
+	if err != nil || d <= 0 {
+		b.sendMessageToGroup(chatID, tr(lang, "mute_usage"))
+		return
+	}
+
+	until := clock.Now().Add(d)
+	b.mu.Lock()
+	b.updateChat(chatID, func(c *ChatInfo) { c.MuteUntil = until })
+	b.saveState()
+	b.mu.Unlock()
+
+	slog.Info("Chat muted", "chat_id", chatID, "until", until)
+	b.sendMessageToGroup(chatID, tr(lang, "muted", until.In(location).Format("02.01 15:04")))
+}
+
+func (b *Bot) handleUnmuteCommand(chatID int64) {
+	b.mu.Lock()
+	b.updateChat(chatID, func(c *ChatInfo) { c.MuteUntil = time.Time{} })
+	b.saveState()
+	lang := b.chatLanguage(chatID)
+	b.mu.Unlock()
+
+	slog.Info("Chat unmuted", "chat_id", chatID)
+	b.sendMessageToGroup(chatID, tr(lang, "unmuted"))
+}
+
+func gridStateText(lang string, gridState int) string {
+	if gridState < 0 { // No reading yet and nothing saved from before a restart
+		return bold(tr(lang, "state_unknown"))
+	}
+	if isGridDown(gridState) {
+		return bold(tr(lang, "state_down"))
+	}
+	return bold(tr(lang, "state_up"))
+}
+
+// isGridDown reports whether a GridToLoad reading means there is no grid.
+// The -1 "unknown" sentinel is never treated as down.
+func isGridDown(gridState int) bool {
+	return gridState >= 0 && gridState <= gridDownThreshold
+}
+
+// getLiveData polls the station's source, retrying failures with exponential backoff
+func (b *Bot) getLiveData(ctx context.Context, st *station) (LuxpowerResponse, error) {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		response, err := fetchLiveData(ctx, b.stationSource(st))
+		if err == nil || attempt >= luxpowerRetries {
+			return response, err
+		}
+
+		slog.Warn("Error getting live data, retrying", "station", st.id, "attempt", attempt, "max_attempts", luxpowerRetries, "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return response, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// fetchLiveData makes a single poll, bounded by LUXPOWER_TIMEOUT
+func fetchLiveData(ctx context.Context, source GridStateSource) (LuxpowerResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, luxpowerTimeout)
+	defer cancel()
+	return source.CurrentGridState(ctx)
+}
+
+// saveState persists the chat list, per-chat settings, outage history and announced grid states.
+// Must be called with b.mu held.
+func (b *Bot) saveState() {
+	st := persistentState{Chats: b.chats, ChatBots: b.chatBots.all(), Pending: b.pending, Outages: b.outages, Since: b.monitoringSince, Stations: make(map[string]stationState)}
+	for _, s := range b.stations {
+		if s.override != "" {
+			if st.Overrides == nil {
+				st.Overrides = make(map[string]string)
+			}
+			st.Overrides[s.id] = s.override
+		}
+		if s.previousGridState < 0 { // Nothing announced yet
+			continue
+		}
+		st.Stations[s.id] = stationState{GridState: s.previousGridState, OutageStart: s.outageStart, Since: s.stateSince}
+	}
+	if err := st.save(stateFile); err != nil {
+		slog.Error("Error saving state", "err", err)
+	}
+}
+
+// isAdmin reports whether the user may run admin commands. Without ADMIN_IDS everyone may.
+func isAdmin(user *tgbotapi.User) bool {
+	if len(adminIDs) == 0 {
+		return true
+	}
+	return user != nil && adminIDs[user.ID]
+}
+
+// parseIDs parses a comma-separated list of Telegram IDs
+func parseIDs(value string) map[int64]bool {
+	ids := make(map[int64]bool)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			slog.Warn("Invalid Telegram ID, ignoring it", "id", field)
+			continue
+		}
+		ids[id] = true
+	}
+	return ids
+}
+
+// loadLocation loads a time zone such as "Europe/Kyiv", falling back to UTC if it is unknown
+func loadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		slog.Warn("Invalid time zone, using UTC", "timezone", name, "err", err)
+		return time.UTC
+	}
+	return loc
+}
+
+// getenv reads a setting from the environment, then from the file named by key+"_FILE" (Docker and
+// Kubernetes secrets), then CONFIG_FILE. An empty env var doesn't hide the others, docker-compose passes
+// every listed var even when it's unset.
 func getenv(key, fallback string) string {
-	if value, exists := os.LookupEnv(key); exists {
+	value, exists := os.LookupEnv(key)
+	if value != "" {
+		return value
+	}
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			secretFileErrs = append(secretFileErrs, fmt.Errorf("%s_FILE: %w", key, err))
+			return fallback
+		}
+		return strings.TrimSpace(string(data))
+	}
+	if value, ok := configFile[key]; ok {
+		return value
+	}
+	if exists {
 		return value
 	}
 	return fallback
 }
 
+// getenvInt parses an integer, falling back on a missing or invalid value
+func getenvInt(key string, fallback int) int {
+	value := getenv(key, "")
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("Invalid setting, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return n
+}
+
+// getenvBool parses a boolean such as "true" or "1", falling back on a missing or invalid value
+func getenvBool(key string, fallback bool) bool {
+	value := getenv(key, "")
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Invalid setting, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return b
+}
+
+// getenvDuration parses a duration such as "90s" or "2m", falling back on a missing or invalid value
+func getenvDuration(key string, fallback time.Duration) time.Duration {
+	value := getenv(key, "")
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		slog.Warn("Invalid setting, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return d
+}
+
 func main() {
-	bot, err := NewBot(telegramBotToken)
+	var logLevel slog.Level // Info by default
+	if value := getenv("LOG_LEVEL", ""); value != "" {
+		if err := logLevel.UnmarshalText([]byte(value)); err != nil {
+			slog.Warn("Invalid setting, using default", "key", "LOG_LEVEL", "value", value, "default", logLevel)
+		}
+	}
+	setupLogging(logLevel)
+	slog.Info("Starting", "version", version, "commit", buildCommit(), "build_date", buildDate)
+
+	if configErr != nil {
+		slog.Error("Error reading CONFIG_FILE", "err", configErr)
+		os.Exit(1)
+	}
+	if err := validateConfig(); err != nil {
+		slog.Error("Invalid configuration", "err", err)
+		os.Exit(1)
+	}
+
+	checkInterval := getenvDuration("CHECK_INTERVAL", defaultCheckInterval)
+	recheckDelay := getenvDuration("RECHECK_DELAY", defaultRecheckDelay)
+	restoreRecheckDelay := getenvDuration("RESTORE_RECHECK_DELAY", recheckDelay) // Unset rechecks a restore like an outage
+	startupGrace = getenvDuration("STARTUP_GRACE", recheckDelay)
+
+	bot, err := NewBot(telegramBotTokens)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("Error starting bot", "err", err)
+		os.Exit(1)
 	}
 
+	if metricsAddr != "" {
+		go bot.serveMetrics(metricsAddr)
+	}
+	if healthAddr != "" {
+		go bot.serveHealth(healthAddr, checkInterval)
+	}
+	if apiAddr != "" {
+		go bot.serveAPI(apiAddr)
+	}
+
+	// Stop cleanly when the container or service is stopped
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// Run the bot
-	bot.Start()
+	if err := bot.Start(ctx, checkInterval, recheckDelay, restoreRecheckDelay); err != nil {
+		slog.Error("Error receiving Telegram updates", "err", err)
+		os.Exit(1)
+	}
 }
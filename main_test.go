@@ -0,0 +1,741 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// fakeClock is a Clock whose time only moves on advance, which runs the timers that fall due
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer // Every timer created, in order
+}
+
+type fakeTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+	fired   bool
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(time.Duration) Ticker { return fakeTicker{} }
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return &fakeTimerHandle{c, t}
+}
+
+// fakeTimerHandle is what AfterFunc returns, Stop goes through the clock's lock
+type fakeTimerHandle struct {
+	c *fakeClock
+	t *fakeTimer
+}
+
+func (h *fakeTimerHandle) Stop() bool {
+	h.c.mu.Lock()
+	defer h.c.mu.Unlock()
+	if h.t.stopped || h.t.fired {
+		return false
+	}
+	h.t.stopped = true
+	return true
+}
+
+// advance moves the time forward by d, running the timers due by then in order. The callbacks run
+// on the caller's goroutine, so the caller must not hold b.mu.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	until := c.now.Add(d)
+	c.mu.Unlock()
+	for {
+		c.mu.Lock()
+		var next *fakeTimer
+		for _, t := range c.timers {
+			if !t.stopped && !t.fired && !t.at.After(until) && (next == nil || t.at.Before(next.at)) {
+				next = t
+			}
+		}
+		if next == nil {
+			c.now = until
+			c.mu.Unlock()
+			return
+		}
+		next.fired = true
+		c.now = next.at
+		c.mu.Unlock()
+		next.f()
+	}
+}
+
+// live returns the timers that are neither stopped nor fired
+func (c *fakeClock) live() []*fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var live []*fakeTimer
+	for _, t := range c.timers {
+		if !t.stopped && !t.fired {
+			live = append(live, t)
+		}
+	}
+	return live
+}
+
+type fakeTicker struct{}
+
+func (fakeTicker) C() <-chan time.Time { return nil }
+func (fakeTicker) Stop()               {}
+
+// fakeSource returns the readings of a sequence like "1,0,e": 1 is the grid up, 0 down, e a failed poll
+// and s the grid up with a steady consumption, as the inverter reports when its data feed stops
+type fakeSource struct {
+	mu       sync.Mutex
+	readings []string
+	onPoll   func() // Called during every poll, if set
+}
+
+var errFakePoll = errors.New("fake poll failure")
+
+func (s *fakeSource) CurrentGridState(context.Context) (LuxpowerResponse, error) {
+	if s.onPoll != nil {
+		s.onPoll()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.readings) == 0 {
+		return LuxpowerResponse{}, errors.New("no readings left")
+	}
+	reading := s.readings[0]
+	s.readings = s.readings[1:]
+	switch reading {
+	case "1":
+		return LuxpowerResponse{GridToLoad: 500}, nil
+	case "0":
+		return LuxpowerResponse{GridToLoad: 0}, nil
+	case "s":
+		pload := 300
+		return LuxpowerResponse{GridToLoad: 500, Pload: &pload}, nil
+	}
+	return LuxpowerResponse{}, errFakePoll
+}
+
+// left returns how many readings haven't been polled yet
+func (s *fakeSource) left() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.readings)
+}
+
+// sentMessage is a message the fake sender got
+type sentMessage struct {
+	chat int64
+	text string
+}
+
+// fakeSender records the messages instead of sending them
+type fakeSender struct {
+	mu     sync.Mutex
+	sent   []sentMessage
+	onSend func() // Called before recording every message, if set
+}
+
+func (s *fakeSender) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if s.onSend != nil {
+		s.onSend()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if msg, ok := c.(tgbotapi.MessageConfig); ok {
+		s.sent = append(s.sent, sentMessage{msg.ChatID, msg.Text})
+	}
+	return tgbotapi.Message{}, nil
+}
+
+func (s *fakeSender) MakeRequest(string, tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// messages returns the recorded messages sorted by chat, as chats are sent to in parallel
+func (s *fakeSender) messages() []sentMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sent := slices.Clone(s.sent)
+	slices.SortStableFunc(sent, func(a, b sentMessage) int { return int(a.chat - b.chat) })
+	return sent
+}
+
+// setGlobal sets a configuration variable for the test, restoring it afterwards
+func setGlobal[T any](t *testing.T, p *T, v T) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// Test chats: 1 gets Ukrainian, 2 English, 3 is unsubscribed and 4 muted, so only 1 and 2 get notifications
+const (
+	chatUK int64 = iota + 1
+	chatEN
+	chatUnsubscribed
+	chatMuted
+)
+
+// testBot is a bot with one station polling a fakeSource of readings, see fakeSource
+type testBot struct {
+	*Bot
+	st     *station
+	clock  *fakeClock
+	source *fakeSource
+	sender *fakeSender
+}
+
+func newTestBot(t *testing.T, readings string) *testBot {
+	fc := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	setGlobal[Clock](t, &clock, fc)
+	setGlobal(t, &stateFile, "")
+	setGlobal(t, &luxpowerRetries, 1)
+	setGlobal(t, &confirmCount, 2)
+	setGlobal(t, &recheckRetries, 3)
+	setGlobal(t, &startupGrace, 0)
+
+	source := &fakeSource{readings: strings.Split(readings, ",")}
+	sender := &fakeSender{}
+	st := newStation("1234", "", source)
+	b := &Bot{
+		shards:   []*shard{{sender: sender, limiter: &rateLimiter{}}},
+		stations: []*station{st},
+		chats: map[int64]ChatInfo{
+			chatUK:           {Subscribed: true},
+			chatEN:           {Subscribed: true, Language: "en"},
+			chatUnsubscribed: {},
+			chatMuted:        {Subscribed: true, MuteUntil: fc.now.Add(time.Hour)},
+		},
+		cooldown:         make(map[int64]time.Time),
+		lastNotification: make(map[string]sentNotification),
+	}
+	return &testBot{Bot: b, st: st, clock: fc, source: source, sender: sender}
+}
+
+// poll runs a regular poll, with rechecks a minute later
+func (tb *testBot) poll() {
+	tb.pollStations(context.Background(), time.Minute, time.Minute)
+}
+
+// step takes the next reading a minute later: the recheck if one is due by then, a regular poll otherwise
+func (tb *testBot) step() {
+	if len(tb.clock.live()) > 0 {
+		tb.clock.advance(time.Minute)
+		return
+	}
+	tb.clock.advance(time.Minute)
+	tb.poll()
+}
+
+// run takes every reading of the source
+func (tb *testBot) run(t *testing.T) {
+	for i := 0; tb.source.left() > 0; i++ {
+		if i > 100 {
+			t.Fatal("readings are not being taken")
+		}
+		tb.step()
+	}
+}
+
+var (
+	outageUK  = sentMessage{chatUK, "🔴 Стан змінився: світла немає."}
+	outageEN  = sentMessage{chatEN, "🔴 Status changed: the grid is down."}
+	restoreUK = sentMessage{chatUK, "🟢 Стан змінився: світло є.\nСвітла не було: 2 хв"}
+	restoreEN = sentMessage{chatEN, "🟢 Status changed: the grid is back.\nThe outage lasted 2 min"}
+)
+
+func TestStateMachine(t *testing.T) {
+	tests := []struct {
+		name     string
+		readings string
+		want     []sentMessage
+		down     bool // Announced state after the readings
+	}{
+		{"outage confirmed, restore pending", "1,0,0,1", []sentMessage{outageEN, outageUK}, true},
+		{"single down reading", "0,1,0", nil, false},
+		{"failed poll before an outage", "e,0,0", []sentMessage{outageUK, outageEN}, true},
+		{"outage and restore", "1,0,0,1,1", []sentMessage{outageUK, outageEN, restoreUK, restoreEN}, false},
+		{"steady grid", "1,1,1", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTestBot(t, tt.readings)
+			tb.run(t)
+
+			want := slices.Clone(tt.want)
+			slices.SortStableFunc(want, func(a, b sentMessage) int { return int(a.chat - b.chat) })
+			if got := tb.sender.messages(); !slices.Equal(got, want) {
+				t.Errorf("sent %v, want %v", got, want)
+			}
+			tb.mu.Lock()
+			defer tb.mu.Unlock()
+			if down := isGridDown(tb.st.previousGridState); down != tt.down {
+				t.Errorf("announced down = %v, want %v", down, tt.down)
+			}
+		})
+	}
+}
+
+func TestRecheckRetries(t *testing.T) {
+	t.Run("announced after failed rechecks", func(t *testing.T) {
+		tb := newTestBot(t, "1,0,e,e,0")
+		tb.run(t)
+
+		want := []sentMessage{outageUK, outageEN}
+		if got := tb.sender.messages(); !slices.Equal(got, want) {
+			t.Errorf("sent %v, want %v", got, want)
+		}
+	})
+
+	t.Run("dropped after RECHECK_RETRIES", func(t *testing.T) {
+		tb := newTestBot(t, "1,0,e,e,e,e")
+		tb.run(t)
+
+		// The recheck after the down reading, then one reschedule per retry
+		if got, want := len(tb.clock.timers), 1+recheckRetries; got != want {
+			t.Errorf("%d rechecks scheduled, want %d", got, want)
+		}
+		if live := tb.clock.live(); len(live) != 0 {
+			t.Errorf("%d rechecks still scheduled after the last retry", len(live))
+		}
+		if got := tb.sender.messages(); len(got) != 0 {
+			t.Errorf("sent %v, want nothing", got)
+		}
+		tb.mu.Lock()
+		defer tb.mu.Unlock()
+		if tb.st.recheckPending || tb.st.recheckFailures != recheckRetries {
+			t.Errorf("recheck pending = %v after %d failures, want false after %d", tb.st.recheckPending, tb.st.recheckFailures, recheckRetries)
+		}
+	})
+}
+
+func TestConfirmCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		confirm  int
+		readings string
+		want     []sentMessage
+	}{
+		{"single restore reading", 2, "0,0,1,0", []sentMessage{outageUK, outageEN}},
+		{"single restore reading after an outage", 2, "1,0,0,1,0", []sentMessage{outageUK, outageEN}},
+		{"outage short of three readings", 3, "1,0,0,1", nil},
+		{"restore short of three readings", 3, "1,0,0,0,1,1,0", []sentMessage{outageUK, outageEN}},
+		{"three readings each way", 3, "1,0,0,0,1,1,1", []sentMessage{
+			outageUK,
+			outageEN,
+			{chatUK, "🟢 Стан змінився: світло є.\nСвітла не було: 3 хв"},
+			{chatEN, "🟢 Status changed: the grid is back.\nThe outage lasted 3 min"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTestBot(t, tt.readings)
+			confirmCount = tt.confirm
+			tb.run(t)
+
+			want := slices.Clone(tt.want)
+			slices.SortStableFunc(want, func(a, b sentMessage) int { return int(a.chat - b.chat) })
+			if got := tb.sender.messages(); !slices.Equal(got, want) {
+				t.Errorf("sent %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSingleRecheck(t *testing.T) {
+	tb := newTestBot(t, "1,0,0,0,1,0")
+	confirmCount = 5
+	tb.poll()
+
+	// Polls closer together than the recheck delay keep the one recheck scheduled by the first down reading
+	for range 3 {
+		tb.clock.advance(10 * time.Second)
+		tb.poll()
+		if live := tb.clock.live(); len(live) != 1 || live[0] != tb.clock.timers[0] {
+			t.Fatalf("%d rechecks scheduled after repeated down readings, want the first one only", len(live))
+		}
+	}
+
+	// Changing back before the confirmation stops it
+	tb.clock.advance(10 * time.Second)
+	tb.poll()
+	if live := tb.clock.live(); len(live) != 0 {
+		t.Fatalf("%d rechecks still scheduled after the change was dropped", len(live))
+	}
+	if !tb.clock.timers[0].stopped {
+		t.Error("the stale recheck wasn't stopped")
+	}
+
+	// The next change gets a recheck of its own
+	tb.clock.advance(10 * time.Second)
+	tb.poll()
+	if live := tb.clock.live(); len(live) != 1 || live[0] != tb.clock.timers[1] {
+		t.Fatalf("%d rechecks scheduled for a new change, want one new", len(live))
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if !tb.st.recheckPending || tb.st.changeCount != 1 {
+		t.Errorf("recheck pending = %v with %d changed readings, want true with 1", tb.st.recheckPending, tb.st.changeCount)
+	}
+}
+
+func TestSwitchStationDropsReadingInFlight(t *testing.T) {
+	tb := newTestBot(t, "1,0")
+	tb.poll()
+
+	// /setstation switches while the down reading of the old station is on its way
+	tb.source.onPoll = func() {
+		tb.mu.Lock()
+		defer tb.mu.Unlock()
+		tb.switchStation(tb.st, "5678", &fakeSource{})
+	}
+	tb.clock.advance(time.Minute)
+	tb.poll()
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.st.changeCount != 0 || tb.st.recheckPending || tb.st.gridPower != -1 {
+		t.Errorf("reading of the old station was used: %d changed readings, recheck pending = %v, grid power %d", tb.st.changeCount, tb.st.recheckPending, tb.st.gridPower)
+	}
+	if live := tb.clock.live(); len(live) != 0 {
+		t.Errorf("%d rechecks scheduled for the old station", len(live))
+	}
+}
+
+func TestMuteUntil(t *testing.T) {
+	tb := newTestBot(t, "1,0,0,1,1")
+	tb.run(t)
+	for _, m := range tb.sender.messages() {
+		if m.chat == chatMuted {
+			t.Fatalf("muted chat got %q", m.text)
+		}
+	}
+
+	// The mute is over by the fake clock, whatever the time is
+	tb.clock.advance(time.Hour)
+	tb.source.readings = []string{"0", "0"}
+	tb.run(t)
+	if got := tb.sender.messages(); !slices.Contains(got, sentMessage{chatMuted, outageUK.text}) {
+		t.Errorf("sent %v, want the outage in the chat that was muted", got)
+	}
+}
+
+func TestClaimChat(t *testing.T) {
+	tb := newTestBot(t, "")
+	senders := []*fakeSender{tb.sender, {}}
+	tb.shards = []*shard{
+		{name: "first_bot", sender: senders[0], limiter: &rateLimiter{}},
+		{name: "second_bot", sender: senders[1], limiter: &rateLimiter{}},
+	}
+	group := &tgbotapi.Chat{ID: -100, Type: "supergroup"}
+	private := &tgbotapi.Chat{ID: 100, Type: "private"}
+
+	steps := []struct {
+		chat  *tgbotapi.Chat
+		shard int
+		want  bool // Whether the bot answers
+	}{
+		{group, 1, true},  // The first bot to hear from a group serves it
+		{group, 0, false}, // and the others stay silent
+		{group, 1, true},
+		{private, 0, true}, // A private chat is served by the bot the user wrote to
+		{private, 1, true}, // even after writing to another one
+		{private, 1, true},
+		{group, 0, false},
+		{private, 0, true},
+	}
+	for i, step := range steps {
+		if got := tb.claimChat(step.chat, step.shard); got != step.want {
+			t.Errorf("step %d: bot %d answers chat %d = %v, want %v", i, step.shard, step.chat.ID, got, step.want)
+		}
+	}
+
+	// Messages go out through the bot serving the chat
+	tb.sendMessageToGroup(group.ID, "group")
+	tb.sendMessageToGroup(private.ID, "private")
+	if got, want := senders[0].messages(), []sentMessage{{private.ID, "private"}}; !slices.Equal(got, want) {
+		t.Errorf("first bot sent %v, want %v", got, want)
+	}
+	if got, want := senders[1].messages(), []sentMessage{{group.ID, "group"}}; !slices.Equal(got, want) {
+		t.Errorf("second bot sent %v, want %v", got, want)
+	}
+}
+
+func TestNoSendWhileClosing(t *testing.T) {
+	tb := newTestBot(t, "")
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.closing = true
+
+	err := tb.sendToAllGroups(categoryGrid, func(string) string { return "test" })
+	if !errors.Is(err, errShuttingDown) {
+		t.Errorf("sending while closing returned %v, want %v", err, errShuttingDown)
+	}
+	if got := tb.sender.messages(); len(got) != 0 {
+		t.Errorf("sent %v while closing", got)
+	}
+	if len(tb.pending) != 2 {
+		t.Errorf("%d notifications pending, want both kept for the next start", len(tb.pending))
+	}
+}
+
+func TestAdminAlertsDontHoldLock(t *testing.T) {
+	tb := newTestBot(t, "e,e,1")
+	setGlobal(t, &adminIDs, map[int64]bool{chatUK: true})
+	setGlobal(t, &pollFailureAlert, 2)
+
+	// Telegram doesn't answer until released
+	release := make(chan struct{})
+	tb.sender.onSend = func() { <-release }
+	tb.poll()
+	tb.poll()
+
+	// The next poll must not wait for the alert of the last one
+	polled := make(chan struct{})
+	go func() {
+		tb.poll()
+		close(polled)
+	}()
+	select {
+	case <-polled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("poll blocked by the admin alert")
+	}
+
+	close(release)
+	tb.sending.Wait()
+	got := tb.sender.messages()
+	for _, want := range []sentMessage{
+		{chatUK, tr("uk", "poll_failing", 2, errFakePoll)},
+		{chatUK, tr("uk", "poll_recovered")},
+	} {
+		if !slices.Contains(got, want) {
+			t.Errorf("sent %v, want %v among them", got, want)
+		}
+	}
+	if len(got) != 2 {
+		t.Errorf("sent %v, want the two alerts only", got)
+	}
+}
+
+func TestStaleDataAlertsDontHoldLock(t *testing.T) {
+	tb := newTestBot(t, "s,s,s,s,1")
+	setGlobal(t, &adminIDs, map[int64]bool{chatUK: true})
+	setGlobal(t, &staleDataThreshold, 2*time.Minute)
+
+	release := make(chan struct{})
+	tb.sender.onSend = func() { <-release }
+	done := make(chan struct{})
+	go func() {
+		tb.run(t)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("polls blocked by the stale data alert")
+	}
+
+	close(release)
+	tb.sending.Wait()
+	got := tb.sender.messages()
+	for _, want := range []sentMessage{
+		{chatUK, tr("uk", "stale_data", formatDuration("uk", 2*time.Minute))},
+		{chatUK, tr("uk", "stale_data_recovered")},
+	} {
+		if !slices.Contains(got, want) {
+			t.Errorf("sent %v, want %v among them", got, want)
+		}
+	}
+	if len(got) != 2 {
+		t.Errorf("sent %v, want the two alerts only", got)
+	}
+}
+
+func TestSwitchStationResetsReadings(t *testing.T) {
+	tb := newTestBot(t, "s,s,s,0")
+	setGlobal(t, &flapCount, 1)
+	tb.run(t)
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.st.importAlerted, tb.st.batteryAlerted, tb.st.staleAlerted, tb.st.flapping = true, true, true, true
+	tb.st.transitions = []time.Time{tb.clock.now}
+	tb.switchStation(tb.st, "5678", &fakeSource{})
+
+	st := tb.st
+	if st.id != "1234" || st.override != "5678" || st.generation != 1 {
+		t.Errorf("station %s polling %s at generation %d, want 1234 polling 5678 at 1", st.id, st.override, st.generation)
+	}
+	if st.gridPower != -1 || st.pload != nil || len(st.recentLoad) != 0 || len(st.samples) != 0 {
+		t.Errorf("readings of the previous station kept: grid %d, load %v, %d recent loads, %d samples", st.gridPower, st.pload, len(st.recentLoad), len(st.samples))
+	}
+	if st.importAlerted || st.batteryAlerted || st.staleAlerted || !st.readingSince.IsZero() {
+		t.Error("alerts of the previous station kept")
+	}
+	if st.flapping || len(st.transitions) != 0 || st.changeCount != 0 || st.recheckPending {
+		t.Error("state machine of the previous station kept")
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	line := strings.Repeat("Світла не було <3 год> & ", 10) // Escaped to a longer text in HTML
+	long := bold(strings.Repeat(line+"\n", 30)) + "\n" + raw("<i>"+strings.Repeat("x\n", 10)+"</i>") + "\n" + strings.Repeat("й", 5000)
+
+	for _, mode := range []string{"", tgbotapi.ModeHTML, tgbotapi.ModeMarkdownV2} {
+		t.Run(cmp.Or(mode, "plain"), func(t *testing.T) {
+			parts := splitMessage(mode, long)
+			if len(parts) < 2 {
+				t.Fatalf("got %d parts, want the text split", len(parts))
+			}
+			for i, part := range parts {
+				if n := utf16Length(part); n > maxMessageLength {
+					t.Errorf("part %d is %d long", i, n)
+				}
+				if mode == tgbotapi.ModeHTML && strings.Count(part, "<b>") != strings.Count(part, "</b>") {
+					t.Errorf("part %d has unbalanced bold: %.40q...", i, part)
+				}
+				if mode == tgbotapi.ModeHTML && strings.Count(part, "<i>") != strings.Count(part, "</i>") {
+					t.Errorf("part %d cuts the raw section", i)
+				}
+				if mode == tgbotapi.ModeMarkdownV2 && strings.HasSuffix(part, `\`) {
+					t.Errorf("part %d ends inside an escape", i)
+				}
+			}
+
+			// Only the bold tags around the cuts and the line breaks at them are added or dropped
+			joined := strings.ReplaceAll(strings.Join(parts, ""), "\n", "")
+			if mode != "" {
+				joined = strings.ReplaceAll(strings.ReplaceAll(joined, boldTags[mode][0], ""), boldTags[mode][1], "")
+			}
+			want := strings.ReplaceAll(formatText(mode, long), "\n", "")
+			if mode != "" {
+				want = strings.ReplaceAll(strings.ReplaceAll(want, boldTags[mode][0], ""), boldTags[mode][1], "")
+			}
+			if joined != want {
+				t.Error("text lost or changed by the split")
+			}
+		})
+	}
+
+	if parts := splitMessage(tgbotapi.ModeHTML, bold("short")); !slices.Equal(parts, []string{"<b>short</b>"}) {
+		t.Errorf("short text split into %q", parts)
+	}
+}
+
+func TestPollBatchesStations(t *testing.T) {
+	tb := newTestBot(t, "1,0")
+	setGlobal(t, &confirmCount, 1)
+	other := newStation("5678", "Офіс", &fakeSource{readings: []string{"1", "0"}})
+	tb.st.name = "Дім"
+	tb.stations = append(tb.stations, other)
+	tb.run(t)
+
+	// Both outages of one poll go out as one message per chat
+	got := tb.sender.messages()
+	if len(got) != 2 {
+		t.Fatalf("sent %v, want one message to each of the two chats", got)
+	}
+	for _, m := range got {
+		if !strings.Contains(m.text, "Дім") || !strings.Contains(m.text, "Офіс") {
+			t.Errorf("chat %d got %q, want both stations in it", m.chat, m.text)
+		}
+	}
+}
+
+func TestUnsubscribeRemovesChat(t *testing.T) {
+	for _, register := range []bool{false, true} {
+		tb := newTestBot(t, "")
+		setGlobal(t, &autoRegister, register)
+		tb.chats[chatEN] = ChatInfo{Subscribed: true, Language: "en", Title: "Group", Disabled: []category{categorySummary}}
+		tb.pending = []pendingNotification{{Chat: chatEN, Text: "late"}, {Chat: chatUK, Text: "late"}}
+
+		tb.handleSubscribeCommand(chatEN, 0, false)
+
+		info, kept := tb.chats[chatEN]
+		if kept != register || info.Subscribed || info.Language != "" || info.Title != "" || info.Disabled != nil {
+			t.Errorf("AUTO_REGISTER=%v: chat left as %+v (kept %v), want it kept only as a bare entry with AUTO_REGISTER", register, info, kept)
+		}
+		if len(tb.pending) != 1 || tb.pending[0].Chat != chatUK {
+			t.Errorf("AUTO_REGISTER=%v: pending %v, want only the other chat's", register, tb.pending)
+		}
+		if got, want := tb.sender.messages(), []sentMessage{{chatEN, tr("en", "unsubscribed")}}; !slices.Equal(got, want) {
+			t.Errorf("AUTO_REGISTER=%v: sent %v, want %v", register, got, want)
+		}
+	}
+}
+
+func TestAPIPollAfterSwitchStation(t *testing.T) {
+	tb := newTestBot(t, "1")
+	setGlobal(t, &apiToken, "secret")
+	poll := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/poll", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		tb.handleAPIPoll(w, r)
+		return w
+	}
+
+	// The poll of the old station hangs until the switch
+	started, release := make(chan struct{}), make(chan struct{})
+	tb.source.onPoll = func() {
+		close(started)
+		<-release
+	}
+	old := make(chan *httptest.ResponseRecorder)
+	go func() { old <- poll() }()
+	<-started
+
+	tb.mu.Lock()
+	tb.switchStation(tb.st, "5678", &fakeSource{readings: []string{"0"}})
+	tb.mu.Unlock()
+
+	// A request after the switch polls the new station instead of sharing the running poll
+	w := poll()
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"grid_state":"down"`) {
+		t.Errorf("poll after the switch: %d %s, want the new station's reading", w.Code, w.Body)
+	}
+
+	close(release)
+	if w := <-old; w.Code != http.StatusBadGateway {
+		t.Errorf("poll running across the switch: %d %s, want it failed", w.Code, w.Body)
+	}
+}
+
+func TestChartNoDataNamesStation(t *testing.T) {
+	tb := newTestBot(t, "")
+	tb.st.name = "Дім"
+	tb.stations = append(tb.stations, newStation("5678", "Офіс", &fakeSource{}))
+
+	tb.handleChartCommand(chatUK)
+
+	want := []sentMessage{
+		{chatUK, "Дім: " + tr("uk", "chart_no_data")},
+		{chatUK, "Офіс: " + tr("uk", "chart_no_data")},
+	}
+	if got := tb.sender.messages(); !slices.Equal(got, want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+}
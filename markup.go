@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Messages are built as plain text with these markers, and formatText turns them into the markup of
+// PARSE_MODE when the message is sent. Everything else is escaped, so station names and other values
+// can't break the markup. They are in the Unicode private use area, so they don't occur in real text.
+const (
+	boldStart = "\uE000"
+	boldEnd   = "\uE001"
+	rawStart  = "\uE002" // Up to rawEnd is already in PARSE_MODE markup, e.g. a custom template
+	rawEnd    = "\uE003"
+)
+
+// boldTags are the opening and closing bold markup of every parse mode, plain text has none
+var boldTags = map[string][2]string{
+	"":                      {"", ""},
+	tgbotapi.ModeHTML:       {"<b>", "</b>"},
+	tgbotapi.ModeMarkdown:   {"*", "*"},
+	tgbotapi.ModeMarkdownV2: {"*", "*"},
+}
+
+// parseMode is the Telegram parse mode of every message, from PARSE_MODE. Empty sends plain text.
+// parseModeErr is reported by validateConfig.
+var parseMode, parseModeErr = parseParseMode(getenv("PARSE_MODE", ""))
+
+// parseParseMode accepts none, HTML, Markdown or MarkdownV2, in any case
+func parseParseMode(value string) (string, error) {
+	if value == "" || strings.EqualFold(value, "none") {
+		return "", nil
+	}
+	for mode := range boldTags {
+		if mode != "" && strings.EqualFold(value, mode) {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("PARSE_MODE must be none, HTML, Markdown or MarkdownV2, not %q", value)
+}
+
+// bold marks text to be shown in bold
+func bold(text string) string {
+	return boldStart + text + boldEnd
+}
+
+// raw marks text that is already in PARSE_MODE markup and must not be escaped
+func raw(text string) string {
+	return rawStart + text + rawEnd
+}
+
+// escapeText escapes the characters that have a meaning in mode
+func escapeText(mode, text string) string {
+	switch mode {
+	case "":
+		return text
+	case tgbotapi.ModeHTML:
+		return htmlEscaper.Replace(text)
+	default:
+		return tgbotapi.EscapeText(mode, text)
+	}
+}
+
+// htmlEscaper escapes what Telegram's HTML needs escaped, and nothing else
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// formatText turns a message with bold and raw markers into mode markup. With an empty mode it
+// returns the plain text, e.g. for the fallback notifiers.
+func formatText(mode, text string) string {
+	var sb strings.Builder
+	for text != "" {
+		i := strings.IndexAny(text, boldStart+boldEnd+rawStart)
+		if i < 0 {
+			sb.WriteString(escapeText(mode, text))
+			break
+		}
+		sb.WriteString(escapeText(mode, text[:i]))
+		marker, size := utf8.DecodeRuneInString(text[i:])
+		text = text[i+size:]
+
+		switch string(marker) {
+		case boldStart:
+			sb.WriteString(boldTags[mode][0])
+		case boldEnd:
+			sb.WriteString(boldTags[mode][1])
+		case rawStart:
+			content, rest, _ := strings.Cut(text, rawEnd)
+			sb.WriteString(content)
+			text = rest
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metrics holds the values exposed at /metrics in the Prometheus text format.
+// It is small enough that pulling in the Prometheus client isn't worth it.
+var metrics = struct {
+	sync.Mutex
+	gridState         map[string]float64 // Per station: 1 if the grid is up, 0 if down
+	pollErrors        map[string]float64 // Per station
+	notificationsSent float64
+}{
+	gridState:  make(map[string]float64),
+	pollErrors: make(map[string]float64),
+}
+
+func setGridStateMetric(station string, up bool) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.gridState[station] = boolToFloat(up)
+}
+
+func incPollErrorsMetric(station string) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.pollErrors[station]++
+}
+
+func incNotificationsSentMetric() {
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.notificationsSent++
+}
+
+// serveMetrics exposes the metrics on addr. It only returns if the server fails.
+func (b *Bot) serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", b.handleMetrics)
+
+	slog.Info("Serving metrics", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("Metrics server failed", "err", err)
+	}
+}
+
+func (b *Bot) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	subscribed := 0
+	for _, c := range b.chats {
+		if c.Subscribed {
+			subscribed++
+		}
+	}
+	b.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	writeMetric(w, "grid_state", "gauge", "Last grid reading of the station: 1 if the grid is up, 0 if it is down.", metrics.gridState)
+	writeMetric(w, "poll_errors_total", "counter", "Failed LuxPower polls, after retries.", metrics.pollErrors)
+	writeMetric(w, "notifications_sent_total", "counter", "Notifications delivered to chats.", metrics.notificationsSent)
+	writeMetric(w, "subscribed_chats", "gauge", "Chats that receive notifications.", float64(subscribed))
+}
+
+// writeMetric writes one metric family. value is either a float64 or a map of station label to value.
+func writeMetric(w io.Writer, name, kind, help string, value any) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+
+	switch v := value.(type) {
+	case float64:
+		fmt.Fprintf(w, "%s %g\n", name, v)
+	case map[string]float64:
+		stations := make([]string, 0, len(v))
+		for station := range v {
+			stations = append(stations, station)
+		}
+		sort.Strings(stations)
+		for _, station := range stations {
+			fmt.Fprintf(w, "%s{station=\"%s\"} %g\n", name, labelEscaper.Replace(station), v[station])
+		}
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func boolToFloat(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
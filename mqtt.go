@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttTimeout bounds waiting for the broker to acknowledge a publish
+const mqttTimeout = 10 * time.Second
+
+// mqttPublisher publishes confirmed grid state changes to MQTT_BROKER for Home Assistant and the like.
+// A nil publisher, without MQTT_BROKER, does nothing.
+type mqttPublisher struct {
+	client mqtt.Client
+}
+
+// mqttState is the payload published on every confirmed state change
+type mqttState struct {
+	Station   string    `json:"station"`
+	Name      string    `json:"name"`
+	State     string    `json:"state"` // "up" or "down"
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// newMQTTPublisher connects to MQTT_BROKER in the background, retrying until the broker is reachable.
+// The availability topic says "online" while connected and the broker sets it to "offline" when the bot goes away.
+func newMQTTPublisher() *mqttPublisher {
+	if mqttBroker == "" {
+		return nil
+	}
+
+	p := &mqttPublisher{}
+	opts := mqtt.NewClientOptions().
+		AddBroker(mqttBroker).
+		SetClientID("luxpower-telegram-bot").
+		SetUsername(mqttUsername).
+		SetPassword(mqttPassword).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetWill(mqttAvailabilityTopic(), "offline", 1, true).
+		SetOnConnectHandler(func(mqtt.Client) {
+			slog.Info("Connected to MQTT broker", "broker", mqttBroker)
+			p.publish(mqttAvailabilityTopic(), "online")
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("Lost connection to MQTT broker, reconnecting", "broker", mqttBroker, "err", err)
+		})
+	p.client = mqtt.NewClient(opts)
+	p.client.Connect()
+	return p
+}
+
+// mqttAvailabilityTopic is where the retained online/offline message goes
+func mqttAvailabilityTopic() string {
+	return mqttTopic + "/availability"
+}
+
+// publishState publishes a confirmed state change of st, retained so subscribers get the state when they connect.
+// With several stations each one has its own topic under MQTT_TOPIC. Must be called with b.mu held.
+func (b *Bot) publishState(st *station, down bool, at time.Time) {
+	if b.mqtt == nil {
+		return
+	}
+
+	state := mqttState{Station: st.id, Name: st.name, State: "up", Timestamp: at.In(location)}
+	if down {
+		state.State = "down"
+	}
+	payload, err := json.Marshal(state)
+	if err != nil {
+		slog.Error("Error encoding MQTT state", "err", err)
+		return
+	}
+
+	topic := mqttTopic
+	if len(b.stations) > 1 {
+		topic += "/" + st.id
+	}
+	b.mqtt.publish(topic, payload)
+}
+
+// publish sends a retained message without waiting for the broker, failures are only logged
+func (p *mqttPublisher) publish(topic string, payload any) {
+	token := p.client.Publish(topic, 1, true, payload)
+	go func() {
+		if !token.WaitTimeout(mqttTimeout) {
+			slog.Warn("MQTT publish timed out", "topic", topic)
+		} else if err := token.Error(); err != nil {
+			slog.Error("Error publishing to MQTT", "topic", topic, "err", err)
+		}
+	}()
+}
+
+// close marks the bot offline and disconnects
+func (p *mqttPublisher) close() {
+	if p == nil {
+		return
+	}
+	p.client.Publish(mqttAvailabilityTopic(), 1, true, "offline").WaitTimeout(mqttTimeout)
+	p.client.Disconnect(250)
+}
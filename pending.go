@@ -0,0 +1,130 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// pendingMaxAge is how long an undelivered notification is retried, a much older alert would only confuse
+const pendingMaxAge = 6 * time.Hour
+
+// pendingNotification is a notification Telegram hasn't confirmed yet. Notifications are saved in the
+// state file before they are sent, so a crash halfway through the chats doesn't lose the rest, and the
+// ones that couldn't be sent are tried again on start and after every poll.
+type pendingNotification struct {
+	Chat    int64     `json:"chat,omitempty"`
+	Channel string    `json:"channel,omitempty"` // @username of a broadcast channel configured by name
+	Thread  int       `json:"thread,omitempty"`
+	Text    string    `json:"text"`
+	At      time.Time `json:"at"` // When it was first sent
+}
+
+// target names the chat for the audit log and duplicate detection
+func (p pendingNotification) target() string {
+	if p.Channel != "" {
+		return p.Channel
+	}
+	return strconv.FormatInt(p.Chat, 10)
+}
+
+// isChannel reports whether p goes to one of BROADCAST_CHANNELS, which get the default language
+// and are never forgotten
+func (p pendingNotification) isChannel() bool {
+	return p.Channel != "" || isBroadcastChannel(p.Chat)
+}
+
+// deliver sends p with text and records it in the audit log. It doesn't touch the bot state, so
+// it runs with b.mu released and several can run at once.
+func (b *Bot) deliver(p pendingNotification, text string) error {
+	msg := tgbotapi.NewMessage(p.Chat, text)
+	if p.Channel != "" {
+		msg = tgbotapi.NewMessageToChannel(p.Channel, text)
+	}
+	err := b.sendInThread(msg, p.Thread)
+	b.audit.recordSend(p.target(), text, err)
+	return err
+}
+
+// deliverAll delivers the notifications of batch, SEND_CONCURRENCY at a time and still within SEND_RATE,
+// so a slow response for one chat doesn't hold up the rest. It returns the error of each, in batch order.
+func (b *Bot) deliverAll(batch []pendingNotification) []error {
+	errs := make([]error, len(batch))
+	slots := make(chan struct{}, sendConcurrency)
+	var wg sync.WaitGroup
+	for i, p := range batch {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = b.deliver(p, p.Text)
+			<-slots
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// afterSend counts a sent notification or forgets a chat that is gone. Must be called with b.mu held.
+func (b *Bot) afterSend(p pendingNotification, err error) {
+	switch {
+	case err == nil:
+		incNotificationsSentMetric()
+	case isChatGone(err) && !p.isChannel():
+		b.forgetChat(p.Chat, err)
+	}
+}
+
+// retryPending sends the notifications left over from a crash or from Telegram being unreachable,
+// noting when they were meant to arrive. It stops at the first failure, as Telegram is then still
+// unreachable. While other sends are running it leaves the retry to the next poll, as those may be
+// the same notifications. Must be called with b.mu held, which is released while sending.
+func (b *Bot) retryPending() {
+	if len(b.pending) == 0 || b.delivering > 0 {
+		return
+	}
+
+	now := clock.Now()
+	for _, p := range slices.Clone(b.pending) {
+		// The chat may have been forgotten while b.mu was released, which drops its notifications
+		if !slices.Contains(b.pending, p) {
+			continue
+		}
+		if now.Sub(p.At) > pendingMaxAge {
+			slog.Warn("Dropping notification that couldn't be sent in time", "chat", p.target(), "at", p.At)
+			b.audit.record(p.target(), p.Text, "expired", nil)
+			b.removePending(p)
+			continue
+		}
+
+		lang := defaultLanguage
+		if !p.isChannel() {
+			lang = b.chatLanguage(p.Chat)
+		}
+		text := p.Text + "\n" + tr(lang, "delayed_notification", p.At.In(location).Format("02.01 15:04"))
+		var err error
+		if !b.unlocked(func() { err = b.deliver(p, text) }) {
+			break
+		}
+		b.afterSend(p, err)
+		if err != nil && isRetryableSendError(err) {
+			break
+		}
+		if err == nil {
+			slog.Info("Sent delayed notification", "chat", p.target(), "at", p.At)
+		}
+		b.removePending(p)
+	}
+	b.saveState()
+}
+
+// removePending drops a notification that was sent or can't ever be. Must be called with b.mu held.
+func (b *Bot) removePending(p pendingNotification) {
+	if i := slices.Index(b.pending, p); i >= 0 {
+		b.pending = slices.Delete(b.pending, i, i+1)
+	}
+}
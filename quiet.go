@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// quietEvent is a notification held back during quiet hours or until the end of a poll
+type quietEvent struct {
+	at       time.Time
+	category category
+	render   func(lang string) string
+}
+
+// quietHours is a daily window, in minutes since midnight in location, during which
+// state changes are queued instead of sent. The window may cross midnight.
+type quietHours struct {
+	start, end int
+}
+
+// parseQuietHours parses QUIET_HOURS_START and QUIET_HOURS_END ("23:00", "06:30").
+// It returns nil, meaning no quiet hours, if either is missing or invalid.
+func parseQuietHours(start, end string) *quietHours {
+	if start == "" || end == "" {
+		return nil
+	}
+
+	s, err1 := time.Parse("15:04", start)
+	e, err2 := time.Parse("15:04", end)
+	if err1 != nil || err2 != nil {
+		slog.Warn("Invalid quiet hours, notifications will not be delayed", "start", start, "end", end)
+		return nil
+	}
+
+	q := &quietHours{start: s.Hour()*60 + s.Minute(), end: e.Hour()*60 + e.Minute()}
+	if q.start == q.end {
+		return nil
+	}
+	return q
+}
+
+// contains reports whether t falls inside the quiet window
+func (q *quietHours) contains(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+
+	t = t.In(location)
+	m := t.Hour()*60 + t.Minute()
+	if q.start < q.end {
+		return m >= q.start && m < q.end
+	}
+	return m >= q.start || m < q.end // The window crosses midnight, e.g. 23:00-06:00
+}
+
+// notify sends a state-change notification of category c, or queues it during quiet hours.
+// During a poll it's collected for flushBatch instead. Must be called with b.mu held, which is
+// released while sending.
+func (b *Bot) notify(c category, render func(lang string) string) {
+	if b.batching > 0 {
+		b.batch = append(b.batch, quietEvent{at: clock.Now(), category: c, render: render})
+		return
+	}
+	if quiet.contains(clock.Now()) {
+		slog.Info("Quiet hours, queueing notification")
+		b.quietQueue = append(b.quietQueue, quietEvent{at: clock.Now(), category: c, render: render})
+		return
+	}
+	b.sendToAllGroups(c, render)
+}
+
+// startBatch collects notifications until flushBatch, so when one poll finds changes at several
+// stations, e.g. in a regional outage, they go out as one message. A recheck during a poll joins its
+// batch. Must be called with b.mu held.
+func (b *Bot) startBatch() {
+	b.batching++
+}
+
+// flushBatch sends the notifications collected since the outermost startBatch, several of them as one
+// message listing them in order. Must be called with b.mu held, which is released while sending.
+func (b *Bot) flushBatch() {
+	if b.batching--; b.batching > 0 {
+		return
+	}
+	batch := b.batch
+	b.batch = nil
+	switch {
+	case len(batch) == 0:
+	case quiet.contains(clock.Now()):
+		slog.Info("Quiet hours, queueing notifications", "count", len(batch))
+		b.quietQueue = append(b.quietQueue, batch...)
+	case len(batch) == 1:
+		b.sendToAllGroups(batch[0].category, batch[0].render)
+	default:
+		b.sendToChats(func(lang string, wants func(category) bool) string {
+			var texts []string
+			for _, event := range batch {
+				if wants(event.category) {
+					texts = append(texts, event.render(lang))
+				}
+			}
+			return strings.Join(texts, "\n\n")
+		})
+	}
+}
+
+// flushQuietQueue sends the notifications queued during quiet hours once they are over, as a single
+// chronological summary ending with the current state. Each chat only gets the categories it wants,
+// and nothing if it wants none of them. Must be called with b.mu held.
+func (b *Bot) flushQuietQueue() {
+	if len(b.quietQueue) == 0 || quiet.contains(clock.Now()) {
+		return
+	}
+
+	queue := b.quietQueue
+	b.quietQueue = nil
+	slog.Info("Quiet hours are over, sending queued notifications", "count", len(queue))
+	b.sendToChats(func(lang string, wants func(category) bool) string {
+		lines := []string{tr(lang, "quiet_summary")}
+		for _, event := range queue {
+			if wants(event.category) {
+				lines = append(lines, event.at.In(location).Format("15:04")+" "+event.render(lang))
+			}
+		}
+		if len(lines) == 1 {
+			return ""
+		}
+		return strings.Join(lines, "\n") + "\n\n" + tr(lang, "quiet_now") + "\n" + b.statusText(lang)
+	})
+}
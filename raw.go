@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// rawInlineLength is the longest response /raw shows in a message, a longer one is sent as a file
+const rawInlineLength = 3500
+
+// handleRawCommand polls every station and replies with the response as the source returned it,
+// to see what changed when the LuxPower cloud changes its response and the parsing breaks
+func (b *Bot) handleRawCommand(chatID int64) {
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	b.mu.Unlock()
+
+	for _, st := range b.stations {
+		data, err := fetchRawData(context.Background(), b.stationSource(st))
+		if err != nil {
+			slog.Error("Error getting raw live data", "station", st.id, "err", err)
+			b.sendMessageToGroup(chatID, b.stationText(st, tr(lang, "fetch_failed")+"\n"+err.Error()))
+			continue
+		}
+
+		// go-luxpower may print other lines around the JSON, that is then shown as it is
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, data, "", "  ") != nil {
+			pretty.Reset()
+			pretty.Write(data)
+		}
+
+		if pretty.Len() > rawInlineLength {
+			b.sendFile(chatID, tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: "raw-" + st.id + ".json", Bytes: pretty.Bytes()}))
+			continue
+		}
+		text := "<pre>" + htmlEscaper.Replace(pretty.String()) + "</pre>"
+		if len(b.stations) > 1 {
+			text = "<b>" + htmlEscaper.Replace(st.name) + "</b>\n" + text
+		}
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ParseMode = tgbotapi.ModeHTML // A code block whatever PARSE_MODE is
+		b.sendMessage(msg)
+	}
+}
+
+// fetchRawData gets the unparsed response of source. A source that can't give one, e.g. a fake in
+// tests, returns the parsed reading as JSON instead.
+func fetchRawData(ctx context.Context, source GridStateSource) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, luxpowerTimeout)
+	defer cancel()
+
+	if raw, ok := source.(rawSource); ok {
+		return raw.RawLiveData(ctx)
+	}
+	response, err := source.CurrentGridState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(response)
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// messageSender delivers messages to Telegram. It's satisfied by *tgbotapi.BotAPI, so tests
+// can record the notifications the state machine sends through a fake.
+type messageSender interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error)
+}
+
+// sentNotification is the last notification sent to a chat, for isDuplicate
+type sentNotification struct {
+	text string
+	at   time.Time
+}
+
+const (
+	sendAttempts     = 3    // How many times a message is tried before giving up
+	maxMessageLength = 4096 // The longest text Telegram accepts, in UTF-16 code units
+)
+
+// rateLimiter spaces calls at least interval apart
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time // When the next call may go
+}
+
+// wait blocks until the caller may proceed
+func (l *rateLimiter) wait() {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// pause holds back all calls for d, e.g. when Telegram asks us to slow down
+func (l *rateLimiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); l.next.Before(until) {
+		l.next = until
+	}
+}
+
+// sendToAllGroups sends a notification of category c to every subscribed chat that isn't muted and
+// hasn't turned c off, rendered in the chat's language, and to BROADCAST_CHANNELS in the default language.
+// Chats that no longer exist are dropped. It returns the errors of the chats it couldn't reach.
+// Must be called with b.mu held, which is released while sending.
+func (b *Bot) sendToAllGroups(c category, render func(lang string) string) error {
+	return b.sendToChats(func(lang string, wants func(category) bool) string {
+		if !wants(c) {
+			return ""
+		}
+		return render(lang)
+	})
+}
+
+// sendToChats is sendToAllGroups for messages that mix categories. render gets the categories the chat
+// wants and returns an empty text to skip it. The chats are sent to in parallel, see deliverAll. The
+// messages are saved as pending until Telegram confirms them, see retryPending. If Telegram can't be
+// reached, the message also goes to the fallback notifiers. Must be called with b.mu held. It's released
+// while sending, so a slow Telegram doesn't hold up commands and polls, and taken again before returning.
+func (b *Bot) sendToChats(render func(lang string, wants func(category) bool) string) error {
+	now := clock.Now()
+	var batch []pendingNotification
+	for chatID, info := range b.chats {
+		if !info.Subscribed || now.Before(info.MuteUntil) || isBroadcastChannel(chatID) {
+			continue
+		}
+		text := render(b.chatLanguage(chatID), func(c category) bool { return b.wantsCategory(chatID, c) })
+		if text == "" {
+			continue
+		}
+		chat := strconv.FormatInt(chatID, 10)
+		if b.isDuplicate(chat, text) {
+			b.audit.record(chat, text, "duplicate", nil)
+			continue
+		}
+		batch = append(batch, pendingNotification{Chat: chatID, Thread: info.Thread, Text: text, At: now})
+	}
+
+	// Configured channels get every category, and are never forgotten
+	all := func(category) bool { return true }
+	for _, c := range broadcastChannels {
+		text := render(defaultLanguage, all)
+		if text == "" {
+			continue
+		}
+		if b.isDuplicate(c.String(), text) {
+			b.audit.record(c.String(), text, "duplicate", nil)
+			continue
+		}
+		batch = append(batch, pendingNotification{Chat: c.id, Channel: c.username, Text: text, At: now})
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	// Saved before sending, so the chats not reached yet still get it if the bot crashes halfway
+	b.pending = append(b.pending, batch...)
+	b.saveState()
+	fallback := render(defaultLanguage, all) // render needs b.mu
+
+	var sendErrs []error
+	if !b.unlocked(func() { sendErrs = b.deliverAll(batch) }) {
+		return errShuttingDown // Still pending, so the next start sends it
+	}
+
+	var errs []error
+	unreachable := false
+	for i, err := range sendErrs {
+		p := batch[i]
+		b.afterSend(p, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("chat %s: %w", p.target(), err))
+		}
+		if err != nil && isRetryableSendError(err) {
+			unreachable = true
+			continue
+		}
+		b.removePending(p)
+	}
+	b.saveState()
+
+	if unreachable && fallback != "" {
+		sendFallback(formatText("", fallback))
+	}
+	return errors.Join(errs...)
+}
+
+// errShuttingDown is returned for a send that wasn't started because the bot is shutting down
+var errShuttingDown = errors.New("shutting down")
+
+// unlocked runs f, which sends to Telegram, with b.mu released. The bot state may change meanwhile,
+// so the caller must not rely on what it read before. Once Start is shutting down f isn't run and
+// it returns false, so Start's wait for the running sends can't race with new ones.
+// Must be called with b.mu held.
+func (b *Bot) unlocked(f func()) bool {
+	if b.closing {
+		return false
+	}
+	b.delivering++
+	b.sending.Add(1)
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.delivering--
+		b.sending.Done()
+	}()
+	f()
+	return true
+}
+
+// sendAsync runs f, which sends to Telegram, on a goroutine of its own, for callers that can't release
+// b.mu halfway through. Like unlocked, it doesn't run f once Start is shutting down. Must be called with b.mu held.
+func (b *Bot) sendAsync(f func()) {
+	if b.closing {
+		slog.Warn("Shutting down, not sending")
+		return
+	}
+	b.sending.Add(1)
+	go func() {
+		defer b.sending.Done()
+		f()
+	}()
+}
+
+// isDuplicate reports whether text was already sent to chat within DEDUP_WINDOW, and otherwise
+// remembers it as the last notification. It guards against double alerts whatever their cause.
+// Must be called with b.mu held.
+func (b *Bot) isDuplicate(chat, text string) bool {
+	now := clock.Now()
+	if last, ok := b.lastNotification[chat]; ok && last.text == text && now.Sub(last.at) < dedupWindow {
+		slog.Warn("Suppressing duplicate notification", "chat", chat, "sent_ago", now.Sub(last.at))
+		return true
+	}
+	b.lastNotification[chat] = sentNotification{text: text, at: now}
+	return false
+}
+
+// forgetChat removes a chat and its settings from the bot and the state file. Must be called with b.mu held.
+func (b *Bot) forgetChat(chatID int64, reason error) {
+	title := b.chats[chatID].Title
+	delete(b.chats, chatID)
+	delete(b.cooldown, chatID)
+	b.chatBots.remove(chatID)
+	b.pending = slices.DeleteFunc(b.pending, func(p pendingNotification) bool { return p.Chat == chatID && !p.isChannel() })
+	b.saveState()
+	slog.Info("Removed chat the bot can no longer write to", "chat_id", chatID, "title", title, "reason", reason)
+}
+
+func (b *Bot) sendMessageToGroup(chatID int64, message string) error {
+	return b.sendMessage(tgbotapi.NewMessage(chatID, message))
+}
+
+func (b *Bot) sendMessage(msg tgbotapi.MessageConfig) error {
+	return b.sendInThread(msg, 0)
+}
+
+// sendInThread sends msg to a forum topic, or to the chat itself if threadID is 0.
+// A text too long for Telegram goes as several messages, the reply markup comes with the last one.
+func (b *Bot) sendInThread(msg tgbotapi.MessageConfig, threadID int) error {
+	chatID := strconv.FormatInt(msg.ChatID, 10)
+	if msg.ChannelUsername != "" {
+		chatID = msg.ChannelUsername
+	}
+	sh := b.shardFor(chatID)
+
+	// A text the caller already put in markup is kept whole, anything else is formatted part by part
+	text := msg.Text
+	if msg.ParseMode != "" {
+		text = raw(text)
+	} else {
+		msg.ParseMode = parseMode
+	}
+
+	parts := splitMessage(msg.ParseMode, text)
+	for i, text := range parts {
+		part := msg
+		part.Text = text
+		if i < len(parts)-1 {
+			part.ReplyMarkup = nil
+		}
+		if err := sendWithRetries(sh.limiter, chatID, func() error { return sh.send(part, threadID) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitMessage formats text, with the markers of formatText, in mode and splits it into parts whose
+// markup fits in maxMessageLength as Telegram counts it. It's split before formatting, so a cut never
+// lands inside a tag or an escape: at line breaks where possible, never inside a raw section, and bold
+// cut in two is closed and reopened. Nothing is dropped, so a long /history or summary needs no
+// "more records" note, it just goes as several messages.
+func splitMessage(mode, text string) []string {
+	// Room for the bold tags added around a cut
+	limit := maxMessageLength - utf16Length(boldTags[mode][0]+boldTags[mode][1])
+
+	var chunks []string
+	chunk, size := "", 0
+	for _, line := range markupLines(text) {
+		n := markupLength(mode, line)
+		if size > 0 && size+n > limit {
+			chunks = append(chunks, chunk)
+			chunk, size = "", 0
+		}
+		for n > limit { // A line too long on its own is cut where it must be
+			var head string
+			head, line = cutMarkup(mode, line, limit)
+			chunks = append(chunks, head)
+			n = markupLength(mode, line)
+		}
+		chunk += line
+		size += n
+	}
+	chunks = append(chunks, chunk)
+
+	parts := make([]string, 0, len(chunks))
+	inBold := false
+	for _, chunk := range chunks {
+		chunk = strings.TrimSuffix(chunk, "\n")
+		if inBold {
+			chunk = boldStart + chunk
+		}
+		if inBold = strings.Count(chunk, boldStart) > strings.Count(chunk, boldEnd); inBold {
+			chunk += boldEnd
+		}
+		parts = append(parts, formatText(mode, chunk))
+	}
+	return parts
+}
+
+// markupLines splits text after every line break outside a raw section, whose markup must stay whole
+func markupLines(text string) []string {
+	var lines []string
+	start, inRaw := 0, false
+	for i, r := range text {
+		switch {
+		case string(r) == rawStart:
+			inRaw = true
+		case string(r) == rawEnd:
+			inRaw = false
+		case r == '\n' && !inRaw:
+			lines = append(lines, text[start:i+1])
+			start = i + 1
+		}
+	}
+	if start < len(text) {
+		lines = append(lines, text[start:])
+	}
+	return lines
+}
+
+// cutMarkup cuts a line of marked-up text so the head's markup fits in limit, between characters
+// or around a raw section. A raw section too long by itself can't be cut and is the whole head.
+func cutMarkup(mode, line string, limit int) (head, tail string) {
+	size := 0
+	for i := 0; i < len(line); {
+		_, unit := utf8.DecodeRuneInString(line[i:])
+		if strings.HasPrefix(line[i:], rawStart) {
+			if end := strings.Index(line[i:], rawEnd); end >= 0 {
+				unit = end + len(rawEnd)
+			} else {
+				unit = len(line) - i
+			}
+		}
+		n := markupLength(mode, line[i:i+unit])
+		if i > 0 && size+n > limit {
+			return line[:i], line[i:]
+		}
+		size += n
+		i += unit
+	}
+	return line, ""
+}
+
+// markupLength is the length of text formatted in mode, as Telegram counts it
+func markupLength(mode, text string) int {
+	return utf16Length(formatText(mode, text))
+}
+
+// utf16Length is the length of text in UTF-16 code units
+func utf16Length(text string) int {
+	n := 0
+	for _, r := range text {
+		n += max(utf16.RuneLen(r), 1)
+	}
+	return n
+}
+
+// sendFile sends a document or photo, retrying like sendInThread
+func (b *Bot) sendFile(chatID int64, file tgbotapi.Chattable) error {
+	sh := b.shardFor(strconv.FormatInt(chatID, 10))
+	return sendWithRetries(sh.limiter, chatID, func() error {
+		_, err := sh.sender.Send(file)
+		return err
+	})
+}
+
+// sendWithRetries makes send attempts under the rate limit of the bot, retrying transient failures with backoff.
+// The final error is logged once and returned.
+func sendWithRetries(limiter *rateLimiter, chatID any, send func() error) error {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		limiter.wait()
+		err := send()
+		if err == nil {
+			return nil
+		}
+		if attempt >= sendAttempts || !isRetryableSendError(err) {
+			slog.Error("Error sending message", "chat_id", chatID, "attempt", attempt, "err", err)
+			return err
+		}
+
+		// On 429 Telegram says how long to wait, everything else backs off exponentially
+		delay := backoff
+		var tgErr *tgbotapi.Error
+		if errors.As(err, &tgErr) && tgErr.RetryAfter > 0 {
+			delay = time.Duration(tgErr.RetryAfter) * time.Second
+		}
+		slog.Warn("Error sending message, retrying", "chat_id", chatID, "attempt", attempt, "max_attempts", sendAttempts, "backoff", delay, "err", err)
+		limiter.pause(delay)
+		backoff *= 2
+	}
+}
+
+// isRetryableSendError reports whether a failed send may succeed later: rate limits, Telegram server
+// errors and network failures are retried, other API errors (bad request, forbidden) are not
+func isRetryableSendError(err error) bool {
+	var tgErr *tgbotapi.Error
+	if !errors.As(err, &tgErr) {
+		return true
+	}
+	return tgErr.Code == http.StatusTooManyRequests || tgErr.RetryAfter > 0 || tgErr.Code >= http.StatusInternalServerError
+}
+
+// isChatGone reports whether Telegram rejected the send because the chat doesn't exist anymore
+// or the bot can't write there: kicked from the group, blocked by the user and the like
+func isChatGone(err error) bool {
+	var tgErr *tgbotapi.Error
+	if !errors.As(err, &tgErr) {
+		return false
+	}
+	switch tgErr.Code {
+	case http.StatusForbidden:
+		return true
+	case http.StatusBadRequest:
+		return strings.Contains(tgErr.Message, "chat not found")
+	}
+	return false
+}
+
+// send makes a single send attempt. MessageConfig has no message_thread_id yet,
+// so messages to a topic are sent as a raw request.
+func (s *shard) send(msg tgbotapi.MessageConfig, threadID int) error {
+	if threadID == 0 {
+		_, err := s.sender.Send(msg)
+		return err
+	}
+
+	params := tgbotapi.Params{"text": msg.Text}
+	if err := params.AddFirstValid("chat_id", msg.ChatID, msg.ChannelUsername); err != nil {
+		return err
+	}
+	params.AddNonZero("message_thread_id", threadID)
+	params.AddNonEmpty("parse_mode", msg.ParseMode)
+	if err := params.AddInterface("reply_markup", msg.ReplyMarkup); err != nil {
+		return err
+	}
+	_, err := s.sender.MakeRequest("sendMessage", params)
+	return err
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// handleSetStationCommand makes a station poll another LuxPower station ID, e.g. to watch a neighbour's
+// inverter for a while, until /setstation default. The new ID is only taken after a test poll of it works.
+// With several stations the first argument picks the station by name or ID.
+func (b *Bot) handleSetStationCommand(chatID int64, args string) {
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	b.mu.Unlock()
+
+	fields := strings.Fields(args)
+	st := b.stations[0]
+	if len(b.stations) > 1 && len(fields) == 2 {
+		if st = b.findStation(fields[0]); st == nil {
+			b.sendMessageToGroup(chatID, tr(lang, "setstation_unknown", fields[0]))
+			return
+		}
+		fields = fields[1:]
+	}
+	if len(fields) != 1 {
+		b.sendMessageToGroup(chatID, tr(lang, "setstation_usage"))
+		return
+	}
+
+	override := fields[0]
+	if override == "default" || override == st.id {
+		override = ""
+	}
+	source := newGridStateSource(cmp.Or(override, st.id))
+	state := ""
+	if override != "" {
+		response, err := fetchLiveData(context.Background(), source)
+		if err != nil {
+			slog.Warn("Test poll of the new station failed, keeping the current one", "station", st.id, "override", override, "err", err)
+			b.sendMessageToGroup(chatID, tr(lang, "setstation_failed", override, err))
+			return
+		}
+		state = gridStateText(lang, response.GridToLoad)
+	}
+
+	b.mu.Lock()
+	b.switchStation(st, override, source)
+	b.mu.Unlock()
+
+	if override == "" {
+		b.sendMessageToGroup(chatID, b.stationText(st, tr(lang, "setstation_default", st.id)))
+		return
+	}
+	b.sendMessageToGroup(chatID, b.stationText(st, tr(lang, "setstation_done", override, state)))
+}
+
+// findStation returns the station with the given name or ID, nil if there is none. The stations
+// themselves never change, so b.mu isn't needed.
+func (b *Bot) findStation(key string) *station {
+	for _, st := range b.stations {
+		if st.id == key || strings.EqualFold(st.name, key) {
+			return st
+		}
+	}
+	return nil
+}
+
+// switchStation makes st poll source, for the override ID or the configured one if override is empty.
+// Nothing known about the other station applies to this one: the state machine starts over as after
+// a start without a saved state, and the readings behind /chart, /forecast, the alerts and the stale
+// data check are dropped. Must be called with b.mu held.
+func (b *Bot) switchStation(st *station, override string, source GridStateSource) {
+	slog.Info("Switching station", "station", st.id, "override", override)
+	st.cancelRecheck()
+	b.endOutage(st, clock.Now())
+
+	fresh := newStation(st.id, st.name, source)
+	fresh.override, fresh.generation = override, st.generation+1
+	*st = *fresh
+	b.saveState()
+}
+
+// polledID returns the LuxPower station ID st actually polls, the /setstation override if there is one.
+// Readings are stored under it, so two inverters never end up in one series.
+func (st *station) polledID() string {
+	return cmp.Or(st.override, st.id)
+}
+
+// stationSource returns what st polls, which /setstation may change meanwhile
+func (b *Bot) stationSource(st *station) GridStateSource {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return st.source
+}
+
+// stationGeneration returns the generation of st, to be taken before a poll and compared after it
+func (b *Bot) stationGeneration(st *station) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return st.generation
+}
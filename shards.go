@@ -0,0 +1,139 @@
+package main
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// shard is one of the TELEGRAM_BOT_TOKENS bots. Telegram limits every bot separately, so with several
+// of them each chat is served by one, see claimChat, and each has its own SEND_RATE.
+type shard struct {
+	api     *tgbotapi.BotAPI
+	name    string        // The bot's username, how chatBots remembers it
+	sender  messageSender // Sends the messages, api outside of tests
+	limiter *rateLimiter
+}
+
+// newShard wraps a bot connected with its token
+func newShard(api *tgbotapi.BotAPI) *shard {
+	return &shard{api: api, name: api.Self.UserName, sender: api, limiter: &rateLimiter{interval: time.Second / time.Duration(sendRate)}}
+}
+
+// chatBots remembers the username of the bot serving each chat. It has a lock of its own, as sends
+// look it up both with and without b.mu held.
+type chatBots struct {
+	mu     sync.Mutex
+	byChat map[int64]string
+}
+
+// get returns the bot serving chatID, empty if none is recorded
+func (c *chatBots) get(chatID int64) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.byChat[chatID]
+}
+
+// set records name as the bot serving chatID and reports whether that changed anything
+func (c *chatBots) set(chatID int64, name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byChat[chatID] == name {
+		return false
+	}
+	if c.byChat == nil {
+		c.byChat = make(map[int64]string)
+	}
+	c.byChat[chatID] = name
+	return true
+}
+
+// remove forgets the bot serving chatID
+func (c *chatBots) remove(chatID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.byChat, chatID)
+}
+
+// all returns a copy of every recorded bot by chat, for the state file
+func (c *chatBots) all() map[int64]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return maps.Clone(c.byChat)
+}
+
+// parseTokens parses a comma-separated list of bot tokens
+func parseTokens(value string) []string {
+	var tokens []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			tokens = append(tokens, field)
+		}
+	}
+	return tokens
+}
+
+// shardIndex picks the bot for chat, its ID or @username, so a chat is always served by the same one
+func (b *Bot) shardIndex(chat string) int {
+	if len(b.shards) == 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(chat))
+	return int(h.Sum32() % uint32(len(b.shards)))
+}
+
+// shardFor returns the bot serving chat, its ID or @username: the one recorded by claimChat, or
+// the one picked by shardIndex for a chat no bot has heard from yet, like a configured channel
+func (b *Bot) shardFor(chat string) *shard {
+	if len(b.shards) == 1 {
+		return b.shards[0]
+	}
+	if id, err := strconv.ParseInt(chat, 10, 64); err == nil {
+		if sh := b.shardNamed(b.chatBots.get(id)); sh != nil {
+			return sh
+		}
+	}
+	return b.shards[b.shardIndex(chat)]
+}
+
+// shardNamed returns the bot with the given username, nil if none of TELEGRAM_BOT_TOKENS is it
+func (b *Bot) shardNamed(name string) *shard {
+	if name == "" {
+		return nil
+	}
+	for _, sh := range b.shards {
+		if sh.name == name {
+			return sh
+		}
+	}
+	return nil
+}
+
+// claimChat reports whether the bot of shard i answers an update from chat, and records it as the bot
+// serving the chat if so. A private chat is served by the bot the user last wrote to, as only the bots
+// the user started may write to them. Any other chat is served by the first bot that got an update from
+// it, so of the bots in a group only one answers, and the chat keeps it. Must not be called with b.mu held.
+func (b *Bot) claimChat(chat *tgbotapi.Chat, i int) bool {
+	if len(b.shards) == 1 {
+		return true
+	}
+
+	sh := b.shards[i]
+	if current := b.shardNamed(b.chatBots.get(chat.ID)); current != nil && current != sh && !chat.IsPrivate() {
+		return false
+	}
+	if b.chatBots.set(chat.ID, sh.name) {
+		slog.Info("Chat is now served by bot", "chat_id", chat.ID, "bot", sh.name)
+		b.mu.Lock()
+		b.saveState()
+		b.mu.Unlock()
+	}
+	return true
+}
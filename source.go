@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"time"
+
+	"mybot/luxpower"
+)
+
+// LuxpowerResponse holds the fields we use from `go-luxpower live --json`.
+// Optional fields are pointers so a value missing from the output can be told apart from 0.
+type LuxpowerResponse struct {
+	GridToLoad int  `json:"GridToLoad"`
+	SOC        *int `json:"SOC"`   // Battery state of charge, %
+	Ppv        *int `json:"Ppv"`   // Solar production, W
+	Pload      *int `json:"Pload"` // Consumption, W
+}
+
+// GridStateSource provides the live readings of one station. The state machine only
+// talks to it, so tests can feed it readings through a fake.
+type GridStateSource interface {
+	CurrentGridState(ctx context.Context) (LuxpowerResponse, error)
+}
+
+// rawSource is a GridStateSource that can also return its response unparsed, for /raw
+type rawSource interface {
+	RawLiveData(ctx context.Context) ([]byte, error)
+}
+
+// newGridStateSource returns the source selected by LUXPOWER_CLIENT for a station: the LuxPower API
+// itself, or the go-luxpower binary kept as a fallback
+func newGridStateSource(station string) GridStateSource {
+	if luxpowerClient == "binary" {
+		return binarySource{station: station}
+	}
+	return httpSource{luxpower.NewClient(luxpowerBaseURL, luxpowerAccount, luxpowerPassword, station)}
+}
+
+// binarySource runs the go-luxpower binary for every poll
+type binarySource struct {
+	station string
+}
+
+func (s binarySource) CurrentGridState(ctx context.Context) (LuxpowerResponse, error) {
+	var response LuxpowerResponse
+	output, err := s.RawLiveData(ctx)
+	if err != nil {
+		return response, err
+	}
+
+	response, err = parseLiveOutput(output)
+	if err != nil {
+		slog.Debug("Unexpected go-luxpower output", "output", string(output))
+		return response, err
+	}
+	return response, nil
+}
+
+// RawLiveData runs go-luxpower and returns everything it printed
+func (s binarySource) RawLiveData(ctx context.Context) ([]byte, error) {
+	// The child is killed when the context expires
+	cmd := exec.CommandContext(ctx, luxpowerBinary, "live", "--json",
+		"--accountname", luxpowerAccount,
+		"--password", luxpowerPassword,
+		"--station", s.station,
+		"--baseurl", luxpowerBaseURL)
+
+	cmd.WaitDelay = time.Second // Don't wait forever on pipes held open by the killed process
+
+	output, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("go-luxpower timed out after %s", luxpowerTimeout)
+	}
+	return output, err
+}
+
+// parseLiveOutput finds the JSON object in the go-luxpower output. Some versions print
+// login or debug lines before it, so every "{" is tried until one starts a valid object.
+func parseLiveOutput(output []byte) (LuxpowerResponse, error) {
+	var response LuxpowerResponse
+	err := errors.New("no JSON object in go-luxpower output")
+	for i := bytes.IndexByte(output, '{'); i >= 0; {
+		// The decoder stops at the end of the object, so anything printed after it doesn't matter
+		if err = json.NewDecoder(bytes.NewReader(output[i:])).Decode(&response); err == nil {
+			return response, nil
+		}
+		response = LuxpowerResponse{}
+
+		next := bytes.IndexByte(output[i+1:], '{')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return response, fmt.Errorf("parsing go-luxpower output: %w", err)
+}
+
+// httpSource talks to the LuxPower API directly
+type httpSource struct {
+	client *luxpower.Client
+}
+
+func (s httpSource) CurrentGridState(ctx context.Context) (LuxpowerResponse, error) {
+	live, err := s.client.Live(ctx)
+	if err != nil {
+		return LuxpowerResponse{}, err
+	}
+	return LuxpowerResponse{GridToLoad: live.GridToLoad, SOC: live.SOC, Ppv: live.Ppv, Pload: live.Pload}, nil
+}
+
+// RawLiveData returns the inverter runtime as the LuxPower API sent it
+func (s httpSource) RawLiveData(ctx context.Context) ([]byte, error) {
+	return s.client.RawLive(ctx)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// outage is a confirmed grid outage. End is zero while the outage is ongoing.
+type outage struct {
+	Station string    `json:"station,omitempty"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end,omitzero"`
+}
+
+// startOutage records the start of a confirmed outage. Must be called with b.mu held.
+func (b *Bot) startOutage(st *station, start time.Time) {
+	b.outages = append(pruneOutages(b.outages, clock.Now()), outage{Station: st.id, Start: start})
+	b.saveState()
+}
+
+// endOutage closes the ongoing outage of st, if any. Must be called with b.mu held.
+func (b *Bot) endOutage(st *station, end time.Time) {
+	for i := len(b.outages) - 1; i >= 0; i-- {
+		if b.outages[i].Station == st.id && b.outages[i].End.IsZero() {
+			b.outages[i].End = end
+			b.saveState()
+			return
+		}
+	}
+}
+
+// stationOutages returns the outages of st. Must be called with b.mu held.
+func (b *Bot) stationOutages(st *station) []outage {
+	// With a single station every record is its own, including ones saved before stations were tracked
+	if len(b.stations) == 1 {
+		return b.outages
+	}
+
+	var outages []outage
+	for _, o := range b.outages {
+		if o.Station == st.id {
+			outages = append(outages, o)
+		}
+	}
+	return outages
+}
+
+// pruneOutages drops outages that ended before the retention period
+func pruneOutages(outages []outage, now time.Time) []outage {
+	cutoff := now.Add(-statsRetention)
+	return slices.DeleteFunc(outages, func(o outage) bool {
+		return !o.End.IsZero() && o.End.Before(cutoff)
+	})
+}
+
+// outageStats sums up the outages overlapping [from, to), clipped to that window.
+// An ongoing outage counts as lasting until to. Outages shorter than MIN_OUTAGE_DURATION in all
+// are left out: such a blip is more likely a glitch in the readings than an outage worth counting.
+func outageStats(outages []outage, from, to time.Time) (count int, total, longest time.Duration) {
+	for _, o := range outages {
+		until := o.End
+		if until.IsZero() {
+			until = clock.Now() // Ongoing, as long as it has lasted so far
+		}
+		if until.Sub(o.Start) < minOutageDuration {
+			continue
+		}
+
+		start, end := o.Start, o.End
+		if end.IsZero() || end.After(to) {
+			end = to
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if !end.After(start) {
+			continue
+		}
+
+		d := end.Sub(start)
+		count++
+		total += d
+		longest = max(longest, d)
+	}
+	return count, total, longest
+}
+
+func (b *Bot) handleStatsCommand(chatID int64) {
+	now := clock.Now().In(location)
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var messages []string
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	for _, st := range b.stations {
+		count, total, longest := outageStats(b.stationOutages(st), dayStart, now)
+		if count == 0 {
+			messages = append(messages, b.stationText(st, tr(lang, "stats_none")))
+			continue
+		}
+
+		messages = append(messages, b.stationText(st, tr(lang, "stats", count, formatDuration(lang, total), formatDuration(lang, longest))))
+	}
+	b.mu.Unlock()
+
+	b.sendMessageToGroup(chatID, strings.Join(messages, "\n\n"))
+}
+
+// reliabilityWindows are the periods /reliability reports, days back from now. Today starts at midnight.
+var reliabilityWindows = []struct {
+	key  string
+	days int
+}{
+	{"reliability_today", 0},
+	{"reliability_week", 7},
+	{"reliability_month", 30},
+}
+
+// handleReliabilityCommand reports the share of time the grid was up. A window reaching back before
+// monitoring started or beyond the retained history only covers the span actually measured.
+func (b *Bot) handleReliabilityCommand(chatID int64) {
+	now := clock.Now().In(location)
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	known := b.monitoringSince
+	if cutoff := now.Add(-statsRetention); cutoff.After(known) {
+		known = cutoff
+	}
+
+	var lines []string
+	for _, st := range b.stations {
+		outages := b.stationOutages(st)
+		for _, w := range reliabilityWindows {
+			from := dayStart
+			if w.days > 0 {
+				from = now.AddDate(0, 0, -w.days)
+			}
+			measured := from
+			if known.After(from) {
+				measured = known
+			}
+			span := now.Sub(measured)
+			if span <= 0 {
+				continue
+			}
+
+			_, total, _ := outageStats(outages, measured, now)
+			text := tr(lang, w.key, 100*(1-total.Seconds()/span.Seconds()), formatDuration(lang, total))
+			if measured.After(from) {
+				text += " " + tr(lang, "reliability_partial", formatSpan(lang, span))
+			}
+			lines = append(lines, b.stationText(st, text))
+		}
+	}
+	b.mu.Unlock()
+
+	b.sendMessageToGroup(chatID, strings.Join(lines, "\n"))
+}
+
+func (b *Bot) handleHistoryCommand(chatID int64) {
+	now := clock.Now()
+
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	var lines []string
+	for i := len(b.outages) - 1; i >= 0 && len(lines) < historySize; i-- {
+		o := b.outages[i]
+		start := o.Start.In(location).Format("02.01 15:04")
+
+		var line string
+		if o.End.IsZero() {
+			line = tr(lang, "history_ongoing", start, formatDuration(lang, now.Sub(o.Start)))
+		} else {
+			end := o.End.In(location).Format("15:04")
+			if o.End.In(location).YearDay() != o.Start.In(location).YearDay() {
+				end = o.End.In(location).Format("02.01 15:04")
+			}
+			line = tr(lang, "history_entry", start, end, formatDuration(lang, o.End.Sub(o.Start)))
+		}
+		if len(b.stations) > 1 {
+			line = b.stationName(o.Station) + ": " + line
+		}
+		lines = append(lines, line)
+	}
+	b.mu.Unlock()
+
+	if len(lines) == 0 {
+		b.sendMessageToGroup(chatID, tr(lang, "history_none"))
+		return
+	}
+	b.sendMessageToGroup(chatID, strings.Join(lines, "\n"))
+}
+
+// handleExportCommand sends all retained outages as a CSV file, for spreadsheets and graphs
+func (b *Bot) handleExportCommand(chatID int64) {
+	now := clock.Now()
+
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"station", "start", "end", "duration_seconds"})
+	for _, o := range b.outages {
+		end, until := "", now // An ongoing outage has no end yet, its duration is so far
+		if !o.End.IsZero() {
+			end, until = o.End.In(location).Format(time.RFC3339), o.End
+		}
+		w.Write([]string{
+			b.stationName(o.Station),
+			o.Start.In(location).Format(time.RFC3339),
+			end,
+			strconv.Itoa(int(until.Sub(o.Start).Seconds())),
+		})
+	}
+	count := len(b.outages)
+	b.mu.Unlock()
+	w.Flush()
+
+	if count == 0 {
+		b.sendMessageToGroup(chatID, tr(lang, "export_none"))
+		return
+	}
+	name := "outages-" + now.In(location).Format("2006-01-02") + ".csv"
+	b.sendFile(chatID, tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: name, Bytes: buf.Bytes()}))
+}
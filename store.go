@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// persistentState is the part of the bot state that survives restarts
+type persistentState struct {
+	Chats    map[int64]ChatInfo      `json:"chats"`
+	Pending  []pendingNotification   `json:"pending,omitempty"` // Not yet confirmed by Telegram
+	Outages  []outage                `json:"outages"`
+	Since    time.Time               `json:"since,omitzero"`     // When monitoring started
+	Stations map[string]stationState `json:"stations,omitempty"` // By station ID
+
+	// Station IDs set with /setstation, by the configured station ID
+	Overrides map[string]string `json:"station_overrides,omitempty"`
+
+	// Username of the bot serving each chat with several TELEGRAM_BOT_TOKENS
+	ChatBots map[int64]string `json:"chat_bots,omitempty"`
+
+	// Per-chat settings of state files from before ChatInfo, merged into Chats on load and never written
+	Languages map[int64]string     `json:"languages,omitempty"`
+	Mutes     map[int64]time.Time  `json:"mutes,omitempty"`
+	Threads   map[int64]int        `json:"threads,omitempty"`
+	Titles    map[int64]string     `json:"titles,omitempty"`
+	Disabled  map[int64][]category `json:"disabled_notifications,omitempty"`
+}
+
+// stationState is the last announced grid state of a station, so a restart doesn't announce it again
+type stationState struct {
+	GridState   int       `json:"grid_state"`
+	OutageStart time.Time `json:"outage_start,omitzero"`
+	Since       time.Time `json:"since,omitzero"`
+}
+
+// loadState reads the state from path. A missing file or an empty path yields an empty state.
+func loadState(path string) (persistentState, error) {
+	st := persistentState{Chats: make(map[int64]ChatInfo)}
+	if path == "" {
+		return st, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, err
+	}
+	if st.Chats == nil {
+		st.Chats = make(map[int64]ChatInfo)
+	}
+	st.migrateChats()
+	return st, nil
+}
+
+// migrateChats moves the per-chat maps of an old state file into Chats
+func (st *persistentState) migrateChats() {
+	update := func(chatID int64, update func(c *ChatInfo)) {
+		c := st.Chats[chatID]
+		update(&c)
+		st.Chats[chatID] = c
+	}
+	for id, lang := range st.Languages {
+		update(id, func(c *ChatInfo) { c.Language = lang })
+	}
+	for id, until := range st.Mutes {
+		update(id, func(c *ChatInfo) { c.MuteUntil = until })
+	}
+	for id, thread := range st.Threads {
+		update(id, func(c *ChatInfo) { c.Thread = thread })
+	}
+	for id, title := range st.Titles {
+		update(id, func(c *ChatInfo) { c.Title = title })
+	}
+	for id, disabled := range st.Disabled {
+		update(id, func(c *ChatInfo) { c.Disabled = disabled })
+	}
+	st.Languages, st.Mutes, st.Threads, st.Titles, st.Disabled = nil, nil, nil, nil, nil
+}
+
+// save writes the state to path atomically, so a crash can't leave a truncated file behind
+func (st persistentState) save(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// handleSubscribersCommand lists the chats that get notifications, with their titles and mutes,
+// and the broadcast channels
+func (b *Bot) handleSubscribersCommand(chatID int64) {
+	now := clock.Now()
+
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	var lines []string
+	for _, id := range slices.Sorted(maps.Keys(b.chats)) {
+		c := b.chats[id]
+		if !c.Subscribed || isBroadcastChannel(id) {
+			continue
+		}
+		line := fmt.Sprint(id)
+		if c.Title != "" {
+			line += " " + c.Title
+		}
+		if now.Before(c.MuteUntil) {
+			line += " " + tr(lang, "subscribers_muted", c.MuteUntil.In(location).Format("02.01 15:04"))
+		}
+		lines = append(lines, line)
+	}
+	b.mu.Unlock()
+
+	for _, c := range broadcastChannels {
+		lines = append(lines, c.String()+" "+tr(lang, "subscribers_channel"))
+	}
+	text := tr(lang, "subscribers", len(lines))
+	if len(lines) > 0 {
+		text += "\n" + strings.Join(lines, "\n")
+	}
+	b.sendMessageToGroup(chatID, text)
+}
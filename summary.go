@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// clockTime is a local time of day, used to schedule the daily summary
+type clockTime struct {
+	hour, minute int
+}
+
+// parseClockTime parses "HH:MM". It returns nil, meaning disabled, if value is empty or invalid.
+func parseClockTime(key, value string) *clockTime {
+	if value == "" {
+		return nil
+	}
+
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		slog.Warn("Invalid time of day, feature disabled", "key", key, "value", value)
+		return nil
+	}
+	return &clockTime{hour: t.Hour(), minute: t.Minute()}
+}
+
+// next returns the first occurrence of c in location after now.
+// Building the date from its parts, not adding 24h, keeps the wall clock time across DST changes.
+func (c *clockTime) next(now time.Time) time.Time {
+	now = now.In(location)
+	t := time.Date(now.Year(), now.Month(), now.Day(), c.hour, c.minute, 0, 0, location)
+	if !t.After(now) {
+		t = time.Date(now.Year(), now.Month(), now.Day()+1, c.hour, c.minute, 0, 0, location)
+	}
+	return t
+}
+
+// runDailySummary sends the summary of the previous day at DAILY_SUMMARY_AT until ctx is cancelled
+func (b *Bot) runDailySummary(ctx context.Context) {
+	for {
+		next := dailySummaryAt.next(clock.Now())
+		slog.Debug("Next daily summary scheduled", "at", next)
+
+		fire := make(chan struct{})
+		timer := clock.AfterFunc(next.Sub(clock.Now()), func() { close(fire) })
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-fire:
+		}
+
+		b.mu.Lock()
+		b.sendDailySummary(next)
+		b.mu.Unlock()
+	}
+}
+
+// sendDailySummary sends the outages of the day before now and the current state to all chats.
+// Without outages nothing is sent, unless DAILY_SUMMARY_ALWAYS is set. Must be called with b.mu held.
+func (b *Bot) sendDailySummary(now time.Time) {
+	render, skip := b.dailySummary(now)
+	if skip {
+		slog.Info("No outages yesterday, skipping daily summary")
+		return
+	}
+	b.sendToAllGroups(categorySummary, render)
+}
+
+// dailySummary renders the summary due at now, of the day before it. skip is true if there were no
+// outages and DAILY_SUMMARY_ALWAYS isn't set. Must be called with b.mu held, and so must render.
+func (b *Bot) dailySummary(now time.Time) (render func(lang string) string, skip bool) {
+	now = now.In(location)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	from := time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, location)
+
+	type summary struct {
+		st    *station
+		count int
+		total time.Duration
+	}
+	summaries := make([]summary, 0, len(b.stations))
+	outages := 0
+	for _, st := range b.stations {
+		count, total, _ := outageStats(b.stationOutages(st), from, to)
+		summaries = append(summaries, summary{st: st, count: count, total: total})
+		outages += count
+	}
+	return func(lang string) string {
+		lines := []string{tr(lang, "summary", from.Format("02.01"))}
+		for _, s := range summaries {
+			text := tr(lang, "summary_none")
+			if s.count > 0 {
+				text = tr(lang, "summary_outages", s.count, formatDuration(lang, s.total))
+			}
+			lines = append(lines, b.stationText(s.st, text))
+		}
+		return strings.Join(lines, "\n") + "\n\n" + b.statusText(lang)
+	}, outages == 0 && !dailySummaryAlways
+}
+
+// handleNextSummaryCommand reports when the next daily summary is due and what it would say so far,
+// to check DAILY_SUMMARY_AT and the timezone without waiting for it
+func (b *Bot) handleNextSummaryCommand(chatID int64) {
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	if dailySummaryAt == nil {
+		b.mu.Unlock()
+		b.sendMessageToGroup(chatID, tr(lang, "next_summary_off"))
+		return
+	}
+
+	next := dailySummaryAt.next(clock.Now())
+	render, skip := b.dailySummary(next)
+	text := tr(lang, "next_summary", next.Format("02.01 15:04"), location)
+	if !b.wantsCategory(chatID, categorySummary) {
+		text += "\n" + tr(lang, "next_summary_disabled")
+	}
+	if skip {
+		text += "\n" + tr(lang, "next_summary_skip")
+	}
+	text += "\n\n" + render(lang)
+	b.mu.Unlock()
+
+	b.sendMessageToGroup(chatID, text)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"cmp"
+	"log/slog"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Custom notification texts from MSG_GRID_DOWN and MSG_GRID_UP, nil uses the built-in messages.
+// They replace the text in every language.
+var (
+	gridDownTemplate = parseTemplate("MSG_GRID_DOWN")
+	gridUpTemplate   = parseTemplate("MSG_GRID_UP")
+)
+
+// Emoji in front of the notifications of each kind, so they stand out in a busy chat. "none" turns one off.
+var (
+	emojiGridDown = getenvEmoji("EMOJI_GRID_DOWN", "🔴")
+	emojiGridUp   = getenvEmoji("EMOJI_GRID_UP", "🟢")
+	emojiBattery  = getenvEmoji("EMOJI_BATTERY", "🔋")
+)
+
+// getenvEmoji reads a message prefix, fallback if it's not set and empty if it's "none"
+func getenvEmoji(key, fallback string) string {
+	value := cmp.Or(getenv(key, ""), fallback)
+	if strings.EqualFold(value, "none") {
+		return ""
+	}
+	return value
+}
+
+// withEmoji puts emoji in front of text, if it's set
+func withEmoji(emoji, text string) string {
+	if emoji == "" {
+		return text
+	}
+	return emoji + " " + text
+}
+
+// notificationData is what the notification templates can use, e.g. {{.Station}} or {{with .SOC}}{{.}}%{{end}}
+type notificationData struct {
+	Station  string // Station name, empty if not configured
+	Duration string // How long the outage lasted, empty if unknown or not over yet
+	SOC      string // Battery charge in %, empty if unknown
+	Runtime  string // Estimated battery runtime or that it's charging, empty without BATTERY_CAPACITY_WH
+}
+
+// parseTemplate parses the template in the env var key. It returns nil if the var is empty or the template invalid.
+func parseTemplate(key string) *template.Template {
+	text := getenv(key, "")
+	if text == "" {
+		return nil
+	}
+
+	tmpl, err := template.New(key).Parse(text)
+	if err != nil {
+		slog.Warn("Invalid message template, using the default", "key", key, "err", err)
+		return nil
+	}
+	return tmpl
+}
+
+// renderTemplate executes tmpl, returning false if it fails so the caller can fall back to the default text.
+// The template is written in PARSE_MODE markup, the values are escaped for it.
+func renderTemplate(tmpl *template.Template, data notificationData) (string, bool) {
+	if tmpl == nil {
+		return "", false
+	}
+	for _, value := range []*string{&data.Station, &data.Duration, &data.SOC, &data.Runtime} {
+		*value = escapeText(parseMode, *value)
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		slog.Error("Error rendering message template", "template", tmpl.Name(), "err", err)
+		return "", false
+	}
+	return raw(sb.String()), true
+}
+
+// notificationData collects the template variables of st. soc is the battery charge when the change
+// was confirmed, a notification held back by quiet hours is rendered later. Must be called with b.mu held.
+func (b *Bot) notificationData(lang string, st *station, soc *int) notificationData {
+	data := notificationData{Station: st.name, Runtime: batteryRuntimeText(lang, st)}
+	if soc != nil {
+		data.SOC = strconv.Itoa(*soc)
+	}
+	return data
+}
+
+// gridDownText renders the outage notification with the battery charge, if the inverter reported it.
+// Must be called with b.mu held.
+func (b *Bot) gridDownText(lang string, st *station, soc *int) string {
+	if text, ok := renderTemplate(gridDownTemplate, b.notificationData(lang, st, soc)); ok {
+		return withEmoji(emojiGridDown, text)
+	}
+	message := b.stationText(st, bold(tr(lang, "grid_down")))
+	if soc != nil {
+		message += "\n" + tr(lang, "battery_soc", *soc)
+	}
+	if runtime := batteryRuntimeText(lang, st); runtime != "" {
+		message += "\n" + runtime
+	}
+	return withEmoji(emojiGridDown, message)
+}
+
+// gridUpText renders the restore notification. outageDuration is 0 if unknown, soc is as in notificationData.
+// Must be called with b.mu held.
+func (b *Bot) gridUpText(lang string, st *station, outageDuration time.Duration, soc *int) string {
+	data := b.notificationData(lang, st, soc)
+	if outageDuration > 0 {
+		data.Duration = formatDuration(lang, outageDuration)
+	}
+	if text, ok := renderTemplate(gridUpTemplate, data); ok {
+		return withEmoji(emojiGridUp, text)
+	}
+
+	message := b.stationText(st, bold(tr(lang, "grid_up")))
+	if outageDuration > 0 {
+		message += "\n" + tr(lang, "outage_lasted", data.Duration)
+	}
+	return withEmoji(emojiGridUp, message)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	updatesPollTimeout  = 60 * time.Second // How long getUpdates waits for an update before returning empty
+	updatesRetryBackoff = 3 * time.Second  // First delay after a failed getUpdates, doubled up to updatesMaxBackoff
+	updatesMaxBackoff   = time.Minute
+
+	// telegramTimeout ends any Telegram request stuck on a dead connection. Without it a getUpdates
+	// hanging forever would silently stop the bot answering commands, while notifications still go out.
+	telegramTimeout = updatesPollTimeout + 30*time.Second
+)
+
+// update is a Telegram update along with fields the Telegram library doesn't decode yet
+type update struct {
+	tgbotapi.Update
+	threadID int // message_thread_id of the message, 0 outside forum topics
+	shard    int // Index in b.shards of the bot that received it
+}
+
+// decodeUpdate decodes a raw update as Telegram sends it
+func decodeUpdate(data []byte) (update, error) {
+	var u update
+	if err := json.Unmarshal(data, &u.Update); err != nil {
+		return u, err
+	}
+
+	var topic struct {
+		Message struct {
+			ThreadID int `json:"message_thread_id"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(data, &topic); err != nil {
+		return u, err
+	}
+	u.threadID = topic.Message.ThreadID
+	return u, nil
+}
+
+// allowedUpdates are the update types handleUpdate acts on. Telegram doesn't send the others, such as
+// edited messages and reactions, which a busy group would otherwise deliver only to be ignored.
+// Members joining and leaving still come as messages.
+var allowedUpdates = []string{"message", "channel_post", "callback_query"}
+
+// receiveUpdates starts receiving Telegram updates over a webhook when WEBHOOK_URL is set, long polling otherwise.
+// The returned stop function ends the delivery and closes the channel.
+func (b *Bot) receiveUpdates() (<-chan update, func(), error) {
+	if webhookURL != "" {
+		return b.listenWebhook()
+	}
+
+	// A webhook left over from an earlier run makes getUpdates fail
+	for _, sh := range b.shards {
+		if _, err := sh.api.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+			return nil, nil, fmt.Errorf("deleting webhook of %s: %w", sh.api.Self.UserName, err)
+		}
+	}
+
+	// Every bot is polled on its own, their updates are merged into one channel
+	updates := make(chan update, b.shards[0].api.Buffer)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, sh := range b.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pollUpdates(sh.api, i, updates, done)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(updates)
+	}()
+
+	// The requests in flight finish in the background, at most the poll timeout later
+	return updates, func() { close(done) }, nil
+}
+
+// pollUpdates long-polls getUpdates of api like tgbotapi's GetUpdatesChan, but decodes the raw updates
+// itself, tagging them with the index of the bot. It returns once done is closed.
+func pollUpdates(api *tgbotapi.BotAPI, shard int, updates chan<- update, done <-chan struct{}) {
+	offset := 0
+	failures, backoff := 0, updatesRetryBackoff
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		params := tgbotapi.Params{"offset": strconv.Itoa(offset), "timeout": strconv.Itoa(int(updatesPollTimeout.Seconds()))}
+		params.AddInterface("allowed_updates", allowedUpdates) // Can't fail for a string slice
+		response, err := api.MakeRequest("getUpdates", params)
+		if err != nil {
+			failures++
+			slog.Error("Error getting updates, retrying", "bot", api.Self.UserName, "err", err, "failures", failures, "retry_in", backoff)
+			select {
+			case <-done:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, updatesMaxBackoff)
+			continue
+		}
+		if failures > 0 {
+			slog.Info("Getting updates works again", "bot", api.Self.UserName, "failures", failures)
+			failures, backoff = 0, updatesRetryBackoff
+		}
+
+		var raw []json.RawMessage
+		if err := json.Unmarshal(response.Result, &raw); err != nil {
+			slog.Error("Error decoding updates", "err", err)
+			continue
+		}
+		for _, data := range raw {
+			u, err := decodeUpdate(data)
+			if err != nil {
+				slog.Error("Error decoding update", "err", err)
+				continue
+			}
+			if u.UpdateID >= offset {
+				offset = u.UpdateID + 1
+				u.shard = shard
+				updates <- u
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import "runtime/debug"
+
+// Build info, set at link time: go build -ldflags "-X main.version=1.2.0 -X main.commit=abc1234 -X main.buildDate=2024-05-01"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// buildCommit returns the commit set at link time or, failing that, the one Go records when building in a git checkout
+func buildCommit() string {
+	if commit != "unknown" {
+		return commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+	}
+	return commit
+}
+
+// handleVersionCommand reports which build is running
+func (b *Bot) handleVersionCommand(chatID int64) {
+	b.mu.Lock()
+	lang := b.chatLanguage(chatID)
+	b.mu.Unlock()
+
+	b.sendMessageToGroup(chatID, tr(lang, "version", version, buildCommit(), buildDate))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// secretHeader carries the webhook secret in every update Telegram posts
+const secretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// listenWebhook registers WEBHOOK_URL with Telegram and serves it on WEBHOOK_LISTEN
+func (b *Bot) listenWebhook() (<-chan update, func(), error) {
+	link, err := url.Parse(webhookURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing WEBHOOK_URL: %w", err)
+	}
+
+	secret := webhookSecret
+	if secret == "" {
+		// Telegram gets the secret on every start, so a random one works as long as nobody else needs to know it
+		buf := make([]byte, 32)
+		rand.Read(buf)
+		secret = hex.EncodeToString(buf)
+	}
+
+	// WebhookConfig has no secret_token yet, so the request is made by hand
+	params := tgbotapi.Params{"url": link.String(), "secret_token": secret}
+	params.AddInterface("allowed_updates", allowedUpdates) // Can't fail for a string slice
+	if _, err := b.shards[0].api.MakeRequest("setWebhook", params); err != nil {
+		return nil, nil, fmt.Errorf("setting webhook: %w", err)
+	}
+
+	updates := make(chan update, b.shards[0].api.Buffer)
+	path := link.Path
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(secret)) != 1 {
+			slog.Warn("Rejected webhook request with a wrong secret", "remote_addr", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			slog.Warn("Error reading webhook request", "remote_addr", r.RemoteAddr, "err", err)
+			return
+		}
+		u, err := decodeUpdate(data)
+		if err != nil {
+			slog.Warn("Invalid webhook request", "remote_addr", r.RemoteAddr, "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		updates <- u
+	})
+
+	server := &http.Server{Addr: webhookListen, Handler: mux}
+	go func() {
+		slog.Info("Serving Telegram webhook", "addr", webhookListen, "path", path)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Webhook server failed", "err", err)
+		}
+	}()
+
+	stop := func() {
+		// Shutdown waits for handlers in flight, so nothing writes to the channel once it is closed
+		if err := server.Shutdown(context.Background()); err != nil {
+			slog.Error("Error stopping webhook server", "err", err)
+		}
+		close(updates)
+	}
+	return updates, stop, nil
+}